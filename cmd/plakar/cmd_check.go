@@ -50,6 +50,11 @@ func cmd_check(ctx Plakar, repository *storage.Repository, args []string) int {
 	for offset, snapshot := range snapshots {
 		_, pattern := parseSnapshotID(flags.Args()[offset])
 
+		// untrusted signatures are rejected by Load when trusted keys are set
+		if !snapshot.IsSigned() {
+			logger.Warn("%s: snapshot is not signed", snapshot.Metadata.Uuid)
+		}
+
 		ok, err := snapshot.Check(pattern, enableFastCheck)
 		if err != nil {
 			logger.Warn("%s", err)
@@ -89,6 +94,10 @@ func check_plakar(repository *storage.Repository) int {
 			continue
 		}
 
+		if !snap.IsSigned() {
+			logger.Warn("%s: snapshot is not signed", snap.Metadata.Uuid)
+		}
+
 		for chunkChecksum := range snap.Index.Chunks {
 			muChunks.Lock()
 			if _, exists := chunks[chunkChecksum]; !exists {
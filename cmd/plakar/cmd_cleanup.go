@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2021 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/poolpOrg/plakar/logger"
+	"github.com/poolpOrg/plakar/storage"
+)
+
+func init() {
+	registerCommand("cleanup", cmd_cleanup)
+}
+
+func cmd_cleanup(ctx Plakar, repository *storage.Repository, args []string) int {
+	flags := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	flags.Parse(args)
+
+	err := repository.Lock(true)
+	if err != nil {
+		logger.Error("%s", err)
+		return 1
+	}
+	defer repository.Unlock()
+
+	transactions, err := repository.GetTransactions()
+	if err != nil {
+		logger.Error("%s", err)
+		return 1
+	}
+
+	// transactions that can be taken over are not in progress anymore,
+	// they were left behind by a process that crashed or was killed
+	failures := 0
+	removed := 0
+	for _, Uuid := range transactions {
+		tx, err := repository.ResumeTransaction(Uuid)
+		if err != nil {
+			if errors.Is(err, storage.ErrTransactionInUse) {
+				logger.Info("%s: in progress, skipping", Uuid)
+			} else {
+				logger.Error("%s: %s", Uuid, err)
+				failures++
+			}
+			continue
+		}
+		err = tx.Rollback()
+		if err != nil {
+			logger.Error("%s: %s", Uuid, err)
+			failures++
+			continue
+		}
+		logger.Info("%s: removed", Uuid)
+		removed++
+	}
+	logger.Info("removed %d stale transactions", removed)
+
+	if failures != 0 {
+		return 1
+	}
+	return 0
+}
@@ -20,8 +20,10 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/google/uuid"
+	"github.com/poolpOrg/plakar/compression"
 	"github.com/poolpOrg/plakar/encryption"
 	"github.com/poolpOrg/plakar/helpers"
 	"github.com/poolpOrg/plakar/storage"
@@ -30,21 +32,33 @@ import (
 func cmd_create(ctx Plakar, args []string) int {
 	var opt_noencryption bool
 	var opt_nocompression bool
+	var opt_compression string
+	var opt_kdf string
+	var opt_recoverykey bool
 
 	flags := flag.NewFlagSet("init", flag.ExitOnError)
 	flags.BoolVar(&opt_noencryption, "no-encryption", false, "disable transparent encryption")
 	flags.BoolVar(&opt_nocompression, "no-compression", false, "disable transparent compression")
+	flags.StringVar(&opt_compression, "compression", "gzip", fmt.Sprintf("compression codec (%s)", strings.Join(compression.Codecs(), ", ")))
+	flags.StringVar(&opt_kdf, "kdf", encryption.DefaultKDF, fmt.Sprintf("key derivation function (%s)", strings.Join(encryption.KDFs(), ", ")))
+	flags.BoolVar(&opt_recoverykey, "recovery-key", false, "generate a printable recovery key")
 	flags.Parse(args)
 
+	if !compression.Exists(opt_compression) {
+		fmt.Fprintf(os.Stderr, "%s: %s: unsupported compression codec: %s\n", flag.CommandLine.Name(), flags.Name(), opt_compression)
+		return 1
+	}
+
 	repositoryConfig := storage.RepositoryConfig{}
 	repositoryConfig.Version = storage.VERSION
 	repositoryConfig.Uuid = uuid.NewString()
 	if opt_nocompression {
-		repositoryConfig.Compression = ""
+		repositoryConfig.Compression = "none"
 	} else {
-		repositoryConfig.Compression = "gzip"
+		repositoryConfig.Compression = opt_compression
 	}
 
+	var recoveryKey string
 	if !opt_noencryption {
 		var passphrase []byte
 		if ctx.KeyFromFile == "" {
@@ -60,7 +74,21 @@ func cmd_create(ctx Plakar, args []string) int {
 		} else {
 			passphrase = []byte(ctx.KeyFromFile)
 		}
-		repositoryConfig.Encryption = encryption.BuildSecretFromPassphrase(passphrase)
+		secret, masterKey, err := encryption.BuildSecretFromPassphrase(passphrase, opt_kdf)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+			return 1
+		}
+
+		if opt_recoverykey {
+			recoveryKey = encryption.NewRecoveryKey()
+			secret, err = encryption.AddKeySlot(secret, masterKey, encryption.RecoveryKeySlot, []byte(recoveryKey), opt_kdf)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+				return 1
+			}
+		}
+		repositoryConfig.Encryption = secret
 	}
 
 	switch flags.NArg() {
@@ -83,5 +111,10 @@ func cmd_create(ctx Plakar, args []string) int {
 		return 1
 	}
 
+	if recoveryKey != "" {
+		fmt.Println("recovery key:", recoveryKey)
+		fmt.Println("store it offline, it unlocks the repository like a passphrase")
+	}
+
 	return 0
 }
@@ -17,12 +17,14 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/user"
+	"sort"
 	"strings"
 
 	"github.com/dustin/go-humanize"
@@ -80,9 +82,10 @@ func cmd_diff(ctx Plakar, repository *storage.Repository, args []string) int {
 					fmt.Println("- ", fiToDiff(*fi1), dir1)
 					continue
 				}
-				if *fi1 != *fi2 {
+				if !fi1.Equal(fi2) {
 					fmt.Println("- ", fiToDiff(*fi1), dir1)
 					fmt.Println("+ ", fiToDiff(*fi2), dir1)
+					diffAttributes(fi1, fi2, dir1)
 				}
 			}
 
@@ -101,9 +104,10 @@ func cmd_diff(ctx Plakar, repository *storage.Repository, args []string) int {
 					fmt.Println("- ", fiToDiff(*fi1), file1)
 					continue
 				}
-				if *fi1 != *fi2 {
+				if !fi1.Equal(fi2) {
 					fmt.Println("- ", fiToDiff(*fi1), file1)
 					fmt.Println("+ ", fiToDiff(*fi2), file1)
+					diffAttributes(fi1, fi2, file1)
 				}
 			}
 
@@ -182,6 +186,38 @@ func fiToDiff(fi filesystem.Fileinfo) string {
 		fi.ModTime.UTC())
 }
 
+func diffAttributes(fi1 *filesystem.Fileinfo, fi2 *filesystem.Fileinfo, pathname string) {
+	diffAttributeMaps("xattr", fi1.Xattrs, fi2.Xattrs, filesystem.FormatXattr, pathname)
+	diffAttributeMaps("acl", fi1.ACLs, fi2.ACLs, filesystem.FormatACL, pathname)
+}
+
+func diffAttributeMaps(kind string, attrs1 map[string][]byte, attrs2 map[string][]byte, format func([]byte) string, pathname string) {
+	names := make([]string, 0)
+	for name := range attrs1 {
+		names = append(names, name)
+	}
+	for name := range attrs2 {
+		if _, exists := attrs1[name]; !exists {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value1, ok1 := attrs1[name]
+		value2, ok2 := attrs2[name]
+		if ok1 && ok2 && bytes.Equal(value1, value2) {
+			continue
+		}
+		if ok1 {
+			fmt.Println("- ", fmt.Sprintf("%s %s=%s", kind, name, format(value1)), pathname)
+		}
+		if ok2 {
+			fmt.Println("+ ", fmt.Sprintf("%s %s=%s", kind, name, format(value2)), pathname)
+		}
+	}
+}
+
 func diff_files(snapshot1 *snapshot.Snapshot, snapshot2 *snapshot.Snapshot, filename1 string, filename2 string) {
 	sum1, ok1 := snapshot1.Index.Pathnames[filename1]
 	sum2, ok2 := snapshot2.Index.Pathnames[filename2]
@@ -33,7 +33,10 @@ func init() {
 }
 
 func cmd_find(ctx Plakar, repository *storage.Repository, args []string) int {
+	var opt_tags arrayFlags
+
 	flags := flag.NewFlagSet("find", flag.ExitOnError)
+	flags.Var(&opt_tags, "tag", "only search snapshots with this tag, may be repeated")
 	flags.Parse(args)
 
 	if flags.NArg() < 1 {
@@ -46,6 +49,16 @@ func cmd_find(ctx Plakar, repository *storage.Repository, args []string) int {
 		log.Fatal(err)
 	}
 	for _, snapshotUuid := range snapshotsList {
+		if len(opt_tags) != 0 {
+			metadata, _, err := snapshot.GetMetadata(repository, snapshotUuid)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if !metadata.HasTags(opt_tags) {
+				continue
+			}
+		}
+
 		snap, err := snapshot.Load(repository, snapshotUuid)
 		if err != nil {
 			log.Fatal(err)
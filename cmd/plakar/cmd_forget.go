@@ -0,0 +1,214 @@
+/*
+ * Copyright (c) 2021 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/poolpOrg/plakar/logger"
+	"github.com/poolpOrg/plakar/snapshot"
+	"github.com/poolpOrg/plakar/storage"
+)
+
+func init() {
+	registerCommand("forget", cmd_forget)
+}
+
+func cmd_forget(ctx Plakar, repository *storage.Repository, args []string) int {
+	var policy snapshot.RetentionPolicy
+	var opt_within string
+	var opt_keepTags arrayFlags
+	var opt_groupBy string
+	var opt_dryRun bool
+
+	flags := flag.NewFlagSet("forget", flag.ExitOnError)
+	flags.IntVar(&policy.Last, "keep-last", 0, "keep the last N snapshots")
+	flags.IntVar(&policy.Hourly, "keep-hourly", 0, "keep the last snapshot of the last N hours")
+	flags.IntVar(&policy.Daily, "keep-daily", 0, "keep the last snapshot of the last N days")
+	flags.IntVar(&policy.Weekly, "keep-weekly", 0, "keep the last snapshot of the last N weeks")
+	flags.IntVar(&policy.Monthly, "keep-monthly", 0, "keep the last snapshot of the last N months")
+	flags.IntVar(&policy.Yearly, "keep-yearly", 0, "keep the last snapshot of the last N years")
+	flags.StringVar(&opt_within, "keep-within", "", "keep snapshots taken within a duration of the latest one (e.g. 30d, 1y6m)")
+	flags.Var(&opt_keepTags, "keep-tag", "keep snapshots with this tag, may be repeated")
+	flags.StringVar(&opt_groupBy, "group-by", "host", "apply the policy to snapshots grouped by host, paths and/or tags")
+	flags.BoolVar(&opt_dryRun, "dry-run", false, "explain which snapshots would be kept without forgetting any")
+	flags.Parse(args)
+
+	for _, rule := range []struct {
+		name  string
+		count int
+	}{
+		{"keep-last", policy.Last},
+		{"keep-hourly", policy.Hourly},
+		{"keep-daily", policy.Daily},
+		{"keep-weekly", policy.Weekly},
+		{"keep-monthly", policy.Monthly},
+		{"keep-yearly", policy.Yearly},
+	} {
+		if rule.count < 0 {
+			fmt.Fprintf(os.Stderr, "%s: %s: -%s must not be negative\n", flag.CommandLine.Name(), flags.Name(), rule.name)
+			flags.Usage()
+			return 1
+		}
+	}
+
+	if opt_within != "" {
+		within, err := parseRetentionDuration(opt_within)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+			return 1
+		}
+		policy.Within = within
+	}
+	policy.Tags = opt_keepTags
+
+	groupBy := make([]string, 0)
+	if opt_groupBy != "" {
+		for _, key := range strings.Split(opt_groupBy, ",") {
+			if key != "host" && key != "paths" && key != "tags" {
+				fmt.Fprintf(os.Stderr, "%s: %s: unsupported grouping: %s\n", flag.CommandLine.Name(), flags.Name(), key)
+				return 1
+			}
+			groupBy = append(groupBy, key)
+		}
+	}
+
+	// without any rule every snapshot would be forgotten
+	if policy.IsEmpty() {
+		fmt.Fprintf(os.Stderr, "%s: %s: need at least one -keep rule\n", flag.CommandLine.Name(), flags.Name())
+		return 1
+	}
+
+	if !opt_dryRun {
+		err := repository.Lock(true)
+		if err != nil {
+			logger.Error("%s", err)
+			return 1
+		}
+		defer repository.Unlock()
+	}
+
+	metadatas, err := getMetadatas(repository, nil)
+	if err != nil {
+		logger.Error("%s", err)
+		return 1
+	}
+
+	groups := make(map[string][]*snapshot.Metadata)
+	for _, metadata := range metadatas {
+		key := snapshot.RetentionGroup(metadata, groupBy)
+		groups[key] = append(groups[key], metadata)
+	}
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	forgotten := 0
+	kept := 0
+	failures := 0
+	for _, key := range keys {
+		if opt_dryRun {
+			logger.Printf("%s:", key)
+		}
+		for _, decision := range policy.Apply(groups[key]) {
+			metadata := decision.Metadata
+
+			hold, err := repository.GetHold(metadata.Uuid)
+			if err != nil {
+				logger.Error("%s: %s", metadata.Uuid, err)
+				failures++
+				continue
+			}
+			if hold != nil && hold.IsActive() {
+				decision.Reasons = append(decision.Reasons, holdString(hold))
+			}
+			if opt_dryRun {
+				if len(decision.Reasons) != 0 {
+					logger.Printf("  keep    %s  %s  %s", metadata.Uuid[:8], metadata.CreationTime.UTC().Format(time.RFC3339), strings.Join(decision.Reasons, ", "))
+				} else {
+					logger.Printf("  forget  %s  %s", metadata.Uuid[:8], metadata.CreationTime.UTC().Format(time.RFC3339))
+				}
+				continue
+			}
+
+			if len(decision.Reasons) != 0 {
+				kept++
+				continue
+			}
+			err = repository.Purge(metadata.Uuid)
+			if err != nil {
+				logger.Error("%s: %s", metadata.Uuid, err)
+				failures++
+				continue
+			}
+			logger.Info("%s: forgotten", metadata.Uuid)
+			forgotten++
+		}
+	}
+
+	if !opt_dryRun {
+		logger.Info("forgot %d snapshots, kept %d", forgotten, kept)
+	}
+
+	if failures != 0 {
+		return 1
+	}
+	return 0
+}
+
+// parseRetentionDuration parses durations such as 30d or 1y6m, which
+// time.ParseDuration doesn't support. Months are 30 days and years 365.
+func parseRetentionDuration(value string) (time.Duration, error) {
+	units := map[byte]time.Duration{
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+		'm': 30 * 24 * time.Hour,
+		'y': 365 * 24 * time.Hour,
+	}
+
+	var duration time.Duration
+	number := ""
+	for i := 0; i < len(value); i++ {
+		if value[i] >= '0' && value[i] <= '9' {
+			number += string(value[i])
+			continue
+		}
+		unit, exists := units[value[i]]
+		if !exists || number == "" {
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+		count, err := strconv.Atoi(number)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+		duration += time.Duration(count) * unit
+		number = ""
+	}
+	if number != "" || duration == 0 {
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+	return duration, nil
+}
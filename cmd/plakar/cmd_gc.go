@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2021 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package main
+
+import (
+	"flag"
+
+	"github.com/dustin/go-humanize"
+	"github.com/poolpOrg/plakar/logger"
+	"github.com/poolpOrg/plakar/snapshot"
+	"github.com/poolpOrg/plakar/storage"
+)
+
+func init() {
+	registerCommand("gc", cmd_gc)
+}
+
+func cmd_gc(ctx Plakar, repository *storage.Repository, args []string) int {
+	var dryRun bool
+
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	flags.BoolVar(&dryRun, "dry-run", false, "report what would be removed without removing it")
+	flags.Parse(args)
+
+	err := repository.Lock(true)
+	if err != nil {
+		logger.Error("%s", err)
+		return 1
+	}
+	defer repository.Unlock()
+
+	report, err := snapshot.GarbageCollect(repository, dryRun)
+	if err != nil {
+		logger.Error("%s", err)
+		return 1
+	}
+
+	if dryRun {
+		logger.Printf("%d objects (%s) and %d chunks (%s) can be removed, %s reclaimable",
+			report.Objects, humanize.Bytes(report.ObjectsSize),
+			report.Chunks, humanize.Bytes(report.ChunksSize),
+			humanize.Bytes(report.ObjectsSize+report.ChunksSize))
+		return 0
+	}
+
+	logger.Info("removed %d objects (%s) and %d chunks (%s), %s reclaimed",
+		report.Objects, humanize.Bytes(report.ObjectsSize),
+		report.Chunks, humanize.Bytes(report.ChunksSize),
+		humanize.Bytes(report.ObjectsSize+report.ChunksSize))
+	if report.InUse != 0 {
+		logger.Info("kept %d unreferenced blobs still used by a transaction", report.InUse)
+	}
+	return 0
+}
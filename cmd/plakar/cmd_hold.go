@@ -0,0 +1,218 @@
+/*
+ * Copyright (c) 2021 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/poolpOrg/plakar/logger"
+	"github.com/poolpOrg/plakar/storage"
+)
+
+func init() {
+	registerCommand("hold", cmd_hold)
+}
+
+func cmd_hold(ctx Plakar, repository *storage.Repository, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s hold set|release|list\n", flag.CommandLine.Name())
+		return 1
+	}
+
+	switch args[0] {
+	case "set":
+		return hold_set(repository, args[1:])
+	case "release":
+		return hold_release(repository, args[1:])
+	case "list":
+		return hold_list(repository, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "%s: hold: unsupported action: %s\n", flag.CommandLine.Name(), args[0])
+		return 1
+	}
+}
+
+func hold_set(repository *storage.Repository, args []string) int {
+	var opt_until string
+	var opt_for string
+
+	flags := flag.NewFlagSet("hold set", flag.ExitOnError)
+	flags.StringVar(&opt_until, "until", "", "hold snapshots until a date (2006-01-02 or RFC3339)")
+	flags.StringVar(&opt_for, "for", "", "hold snapshots for a duration (e.g. 90d, 7y)")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "%s: hold set: need at least one snapshot ID\n", flag.CommandLine.Name())
+		return 1
+	}
+	if opt_until != "" && opt_for != "" {
+		fmt.Fprintf(os.Stderr, "%s: hold set: -until and -for are mutually exclusive\n", flag.CommandLine.Name())
+		return 1
+	}
+
+	hold := storage.Hold{}
+	if opt_until != "" {
+		expiry, err := time.ParseInLocation("2006-01-02", opt_until, time.Local)
+		if err != nil {
+			expiry, err = time.Parse(time.RFC3339, opt_until)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: hold set: invalid date: %s\n", flag.CommandLine.Name(), opt_until)
+			return 1
+		}
+		hold.Expiry = expiry
+	} else if opt_for != "" {
+		duration, err := parseRetentionDuration(opt_for)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: hold set: %s\n", flag.CommandLine.Name(), err)
+			return 1
+		}
+		hold.Expiry = time.Now().Add(duration)
+	}
+	if !hold.IsActive() {
+		fmt.Fprintf(os.Stderr, "%s: hold set: %s is in the past\n", flag.CommandLine.Name(), opt_until)
+		return 1
+	}
+
+	// holds are set under a shared lock so they can't race with purges
+	err := repository.Lock(false)
+	if err != nil {
+		logger.Error("%s", err)
+		return 1
+	}
+	defer repository.Unlock()
+
+	metadatas, err := getMetadatas(repository, flags.Args())
+	if err != nil {
+		logger.Error("%s", err)
+		return 1
+	}
+
+	failures := 0
+	for _, metadata := range metadatas {
+		// a hold is never shortened, it has to be released first
+		current, err := repository.GetHold(metadata.Uuid)
+		if err != nil {
+			logger.Error("%s: %s", metadata.Uuid, err)
+			failures++
+			continue
+		}
+		if current != nil && current.IsActive() &&
+			(current.Expiry.IsZero() || (!hold.Expiry.IsZero() && hold.Expiry.Before(current.Expiry))) {
+			logger.Info("%s: already %s", metadata.Uuid, holdString(current))
+			continue
+		}
+
+		err = repository.PutHold(metadata.Uuid, hold)
+		if err != nil {
+			logger.Error("%s: %s", metadata.Uuid, err)
+			failures++
+			continue
+		}
+		logger.Info("%s: %s", metadata.Uuid, holdString(&hold))
+	}
+
+	if failures != 0 {
+		return 1
+	}
+	return 0
+}
+
+func hold_release(repository *storage.Repository, args []string) int {
+	flags := flag.NewFlagSet("hold release", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "%s: hold release: need at least one snapshot ID\n", flag.CommandLine.Name())
+		return 1
+	}
+
+	err := repository.Lock(false)
+	if err != nil {
+		logger.Error("%s", err)
+		return 1
+	}
+	defer repository.Unlock()
+
+	metadatas, err := getMetadatas(repository, flags.Args())
+	if err != nil {
+		logger.Error("%s", err)
+		return 1
+	}
+
+	failures := 0
+	for _, metadata := range metadatas {
+		err = repository.DeleteHold(metadata.Uuid)
+		if err != nil {
+			logger.Error("%s: %s", metadata.Uuid, err)
+			failures++
+			continue
+		}
+		logger.Info("%s: released", metadata.Uuid)
+	}
+
+	if failures != 0 {
+		return 1
+	}
+	return 0
+}
+
+func hold_list(repository *storage.Repository, args []string) int {
+	flags := flag.NewFlagSet("hold list", flag.ExitOnError)
+	flags.Parse(args)
+
+	metadatas, err := getMetadatas(repository, nil)
+	if err != nil {
+		logger.Error("%s", err)
+		return 1
+	}
+
+	failures := 0
+	for _, metadata := range metadatas {
+		hold, err := repository.GetHold(metadata.Uuid)
+		if err != nil {
+			logger.Error("%s: %s", metadata.Uuid, err)
+			failures++
+			continue
+		}
+		if hold == nil {
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "%s%10s  %s\n",
+			metadata.CreationTime.UTC().Format(time.RFC3339),
+			metadata.Uuid[:8],
+			holdString(hold))
+	}
+
+	if failures != 0 {
+		return 1
+	}
+	return 0
+}
+
+func holdString(hold *storage.Hold) string {
+	if hold.Expiry.IsZero() {
+		return "held indefinitely"
+	}
+	if !hold.IsActive() {
+		return fmt.Sprintf("hold expired %s", hold.Expiry.UTC().Format(time.RFC3339))
+	}
+	return fmt.Sprintf("held until %s", hold.Expiry.UTC().Format(time.RFC3339))
+}
@@ -20,9 +20,11 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
 
 	"github.com/dustin/go-humanize"
+	"github.com/poolpOrg/plakar/encryption"
 	"github.com/poolpOrg/plakar/logger"
 	"github.com/poolpOrg/plakar/snapshot"
 	"github.com/poolpOrg/plakar/storage"
@@ -33,19 +35,29 @@ func init() {
 }
 
 func cmd_info(ctx Plakar, repository *storage.Repository, args []string) int {
+	var opt_tags arrayFlags
+
 	flags := flag.NewFlagSet("info", flag.ExitOnError)
+	flags.Var(&opt_tags, "tag", "only show snapshots with this tag, may be repeated")
 	flags.Parse(args)
 
-	if flags.NArg() == 0 {
+	if flags.NArg() == 0 && len(opt_tags) == 0 {
 		return info_plakar(repository)
 	}
 
-	metadatas, err := getMetadatas(repository, flags.Args())
+	var prefixes []string
+	if flags.NArg() != 0 {
+		prefixes = flags.Args()
+	}
+	metadatas, err := getMetadatas(repository, prefixes)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	for _, metadata := range metadatas {
+		if !metadata.HasTags(opt_tags) {
+			continue
+		}
 		fmt.Printf("Uuid: %s\n", metadata.Uuid)
 		fmt.Printf("CreationTime: %s\n", metadata.CreationTime)
 		fmt.Printf("Version: %s\n", metadata.Version)
@@ -54,10 +66,23 @@ func cmd_info(ctx Plakar, repository *storage.Repository, args []string) int {
 		fmt.Printf("CommandLine: %s\n", metadata.CommandLine)
 		fmt.Printf("MachineID: %s\n", metadata.MachineID)
 		fmt.Printf("PublicKey: %s\n", metadata.PublicKey)
+		fmt.Printf("Tags: %s\n", strings.Join(metadata.Tags, ", "))
+		_, verified, err := snapshot.GetMetadata(repository, metadata.Uuid)
+		if err != nil {
+			fmt.Printf("Signature: %s\n", err)
+		} else {
+			fmt.Printf("Signature: %s\n", snapshot.SignatureStatus(repository, metadata, verified))
+		}
 		fmt.Printf("Directories: %d\n", metadata.Statistics.Directories)
 		fmt.Printf("Files: %d\n", metadata.Statistics.Files)
 		fmt.Printf("NonRegular: %d\n", metadata.Statistics.NonRegular)
 		fmt.Printf("Pathnames: %d\n", metadata.Statistics.Pathnames)
+		fmt.Printf("HardLinks: %d\n", metadata.Statistics.HardLinks)
+		fmt.Printf("Excluded: %d\n", metadata.Statistics.Excluded)
+		fmt.Printf("Skipped: %d\n", metadata.Statistics.Skipped)
+		for _, skipped := range metadata.Skipped {
+			fmt.Printf("  %s (%s)\n", skipped.Pathname, skipped.Reason)
+		}
 		fmt.Printf("Objects: %d\n", metadata.Statistics.Objects)
 		fmt.Printf("Chunks: %d\n", metadata.Statistics.Chunks)
 		fmt.Printf("Duration: %s\n", metadata.Statistics.Duration)
@@ -186,7 +211,11 @@ func info_plakar(repository *storage.Repository) int {
 	}
 
 	if repository.Configuration().Encryption != "" {
-		fmt.Println("Encryption:", repository.Configuration().Encryption)
+		fmt.Println("Encryption:", "yes")
+		slots, err := encryption.KeySlots(repository.Configuration().Encryption)
+		if err == nil {
+			fmt.Println("Key slots:", len(slots))
+		}
 	} else {
 		fmt.Println("Encryption:", "no")
 	}
@@ -22,6 +22,7 @@ import (
 	"strconv"
 	"sync"
 
+	"github.com/poolpOrg/plakar/logger"
 	"github.com/poolpOrg/plakar/snapshot"
 	"github.com/poolpOrg/plakar/storage"
 )
@@ -31,17 +32,29 @@ func init() {
 }
 
 func cmd_keep(ctx Plakar, repository *storage.Repository, args []string) int {
+	var opt_tags arrayFlags
+	var opt_keepTags arrayFlags
+
 	flags := flag.NewFlagSet("keep", flag.ExitOnError)
+	flags.Var(&opt_tags, "tag", "only consider snapshots with this tag, may be repeated")
+	flags.Var(&opt_keepTags, "keep-tag", "never remove snapshots with this tag, may be repeated")
 	flags.Parse(args)
 
 	if flags.NArg() == 0 {
 		log.Fatalf("%s: need a number of snapshots to keep", flag.CommandLine.Name())
 	}
 
-	count, err := strconv.Atoi(args[0])
+	count, err := strconv.Atoi(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("%s: %s: need a number of snapshots to keep", flag.CommandLine.Name(), flags.Arg(0))
+	}
+
+	err = repository.Lock(true)
 	if err != nil {
-		log.Fatalf("%s: %s: need a number of snapshots to keep", flag.CommandLine.Name(), args[0])
+		logger.Error("%s", err)
+		return 1
 	}
+	defer repository.Unlock()
 
 	snapshotsList, err := getSnapshotsList(repository)
 	if err != nil {
@@ -57,15 +70,51 @@ func cmd_keep(ctx Plakar, repository *storage.Repository, args []string) int {
 	}
 
 	wg := sync.WaitGroup{}
-	snapshots = sortSnapshotsByDate(snapshots)[:len(snapshots)-count]
-	for _, snap := range snapshots {
+	for _, snap := range keepPurgeSet(snapshots, count, opt_tags, opt_keepTags) {
 		wg.Add(1)
 		go func(snap *snapshot.Snapshot) {
+			defer wg.Done()
+			hold, err := repository.GetHold(snap.Metadata.Uuid)
+			if err == nil && hold != nil && hold.IsActive() {
+				logger.Info("%s: %s, skipping", snap.Metadata.Uuid, holdString(hold))
+				return
+			}
 			repository.Purge(snap.Metadata.Uuid)
-			wg.Done()
 		}(snap)
 	}
 	wg.Wait()
 
 	return 0
 }
+
+// keepPurgeSet returns the snapshots to remove so that only the count most
+// recent ones carrying all of tags remain, snapshots carrying any of
+// keepTags are never removed.
+func keepPurgeSet(snapshots []*snapshot.Snapshot, count int, tags []string, keepTags []string) []*snapshot.Snapshot {
+	tagged := make([]*snapshot.Snapshot, 0, len(snapshots))
+	for _, snap := range snapshots {
+		if snap.Metadata.HasTags(tags) {
+			tagged = append(tagged, snap)
+		}
+	}
+	if len(tagged) < count {
+		return nil
+	}
+
+	purged := make([]*snapshot.Snapshot, 0, len(tagged)-count)
+	for _, snap := range sortSnapshotsByDate(tagged)[:len(tagged)-count] {
+		kept := ""
+		for _, tag := range keepTags {
+			if snap.Metadata.HasTag(tag) {
+				kept = tag
+				break
+			}
+		}
+		if kept != "" {
+			logger.Info("%s: tagged %s, skipping", snap.Metadata.Uuid, kept)
+			continue
+		}
+		purged = append(purged, snap)
+	}
+	return purged
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2021 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/poolpOrg/plakar/logger"
+	"github.com/poolpOrg/plakar/snapshot"
+)
+
+func TestKeepPurgeSet(t *testing.T) {
+	stop := logger.Start()
+	defer stop()
+
+	tests := []struct {
+		name     string
+		count    int
+		tags     []string
+		keepTags []string
+		purged   []string
+	}{
+		{
+			name:   "untagged",
+			count:  2,
+			purged: []string{"a", "b", "c"},
+		},
+		{
+			name:   "tag only considers tagged snapshots",
+			count:  1,
+			tags:   []string{"nightly"},
+			purged: []string{"a", "b", "c"},
+		},
+		{
+			name:     "keep-tag protects tagged snapshots",
+			count:    2,
+			keepTags: []string{"release"},
+			purged:   []string{"a", "c"},
+		},
+		{
+			name:     "keep-tag matches labels of the same key",
+			count:    1,
+			keepTags: []string{"pre-upgrade", "release"},
+			purged:   []string{"a", "c", "d"},
+		},
+		{
+			name:     "tag and keep-tag",
+			count:    1,
+			tags:     []string{"nightly"},
+			keepTags: []string{"release"},
+			purged:   []string{"a", "c"},
+		},
+		{
+			name:   "fewer snapshots than kept",
+			count:  5,
+			tags:   []string{"nightly"},
+			purged: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		// from the oldest to the most recent
+		snapshots := make([]*snapshot.Snapshot, 0)
+		for i, snap := range []struct {
+			uuid string
+			tags []string
+		}{
+			{"a", []string{"nightly"}},
+			{"b", []string{"release=1.0", "nightly"}},
+			{"c", []string{"nightly"}},
+			{"d", nil},
+			{"e", []string{"nightly"}},
+		} {
+			snapshots = append(snapshots, &snapshot.Snapshot{
+				Metadata: &snapshot.Metadata{
+					Uuid:         snap.uuid,
+					CreationTime: time.Date(2021, 6, 10+i, 12, 0, 0, 0, time.UTC),
+					Tags:         snap.tags,
+				},
+			})
+		}
+		// the order of the snapshots must not matter
+		snapshots[0], snapshots[4] = snapshots[4], snapshots[0]
+
+		purged := make([]string, 0)
+		for _, snap := range keepPurgeSet(snapshots, test.count, test.tags, test.keepTags) {
+			purged = append(purged, snap.Metadata.Uuid)
+		}
+		if !reflect.DeepEqual(purged, test.purged) {
+			t.Errorf("%s: purged %v, expected %v", test.name, purged, test.purged)
+		}
+	}
+}
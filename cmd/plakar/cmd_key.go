@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2021 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/poolpOrg/plakar/encryption"
+	"github.com/poolpOrg/plakar/helpers"
+	"github.com/poolpOrg/plakar/storage"
+)
+
+func init() {
+	registerCommand("key", cmd_key)
+}
+
+func cmd_key(ctx Plakar, repository *storage.Repository, args []string) int {
+	flags := flag.NewFlagSet("key", flag.ExitOnError)
+	flags.Parse(args)
+
+	if repository.GetSecret() == nil {
+		fmt.Fprintf(os.Stderr, "%s: %s: repository is not encrypted\n", flag.CommandLine.Name(), flags.Name())
+		return 1
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "%s: %s: missing subcommand (add, list, remove)\n", flag.CommandLine.Name(), flags.Name())
+		return 1
+	}
+
+	switch flags.Arg(0) {
+	case "add":
+		return cmd_key_add(ctx, repository, flags.Args()[1:])
+	case "list":
+		return cmd_key_list(ctx, repository, flags.Args()[1:])
+	case "remove":
+		return cmd_key_remove(ctx, repository, flags.Args()[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "%s: %s: unknown subcommand: %s\n", flag.CommandLine.Name(), flags.Name(), flags.Arg(0))
+		return 1
+	}
+}
+
+func cmd_key_add(ctx Plakar, repository *storage.Repository, args []string) int {
+	var opt_newkeyfile string
+	var opt_kdf string
+
+	flags := flag.NewFlagSet("key add", flag.ExitOnError)
+	flags.StringVar(&opt_newkeyfile, "new-keyfile", "", "read new passphrase from key file")
+	flags.StringVar(&opt_kdf, "kdf", encryption.DefaultKDF, fmt.Sprintf("key derivation function (%s)", strings.Join(encryption.KDFs(), ", ")))
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "%s: %s: a key slot name must be provided\n", flag.CommandLine.Name(), flags.Name())
+		return 1
+	}
+
+	var passphrase []byte
+	if opt_newkeyfile == "" {
+		for {
+			tmp, err := helpers.GetPassphraseConfirm("new key slot")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				continue
+			}
+			passphrase = tmp
+			break
+		}
+	} else {
+		data, err := ioutil.ReadFile(opt_newkeyfile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s: could not read key file: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+			return 1
+		}
+		passphrase = []byte(strings.TrimSuffix(string(data), "\n"))
+	}
+
+	repositoryConfig := repository.Configuration()
+	secret, err := encryption.AddKeySlot(repositoryConfig.Encryption, repository.GetSecret(), flags.Arg(0), passphrase, opt_kdf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+		return 1
+	}
+
+	repositoryConfig.Encryption = secret
+	err = repository.SetConfiguration(repositoryConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s: could not update configuration: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+		return 1
+	}
+	return 0
+}
+
+func cmd_key_list(ctx Plakar, repository *storage.Repository, args []string) int {
+	flags := flag.NewFlagSet("key list", flag.ExitOnError)
+	flags.Parse(args)
+
+	slots, err := encryption.KeySlots(repository.Configuration().Encryption)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+		return 1
+	}
+
+	for _, slot := range slots {
+		current := " "
+		if slot.Name == ctx.KeySlot {
+			current = "*"
+		}
+		created := "-"
+		if !slot.Created.IsZero() {
+			created = slot.Created.UTC().Format(time.RFC3339)
+		}
+		fmt.Printf("%s %-20s  %20s  %s\n", current, slot.Name, created, slot.KDF)
+	}
+	return 0
+}
+
+func cmd_key_remove(ctx Plakar, repository *storage.Repository, args []string) int {
+	flags := flag.NewFlagSet("key remove", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s key remove name...\n\n", flag.CommandLine.Name())
+		fmt.Fprintf(flags.Output(), "Removes key slots so their passphrases no longer unlock the repository.\n")
+		fmt.Fprintf(flags.Output(), "This is not a revocation: the master key doesn't change, anyone who\n")
+		fmt.Fprintf(flags.Output(), "unlocked the repository or kept a copy of its configuration can still\n")
+		fmt.Fprintf(flags.Output(), "decrypt existing and future snapshots.\n")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "%s: %s: a key slot name must be provided\n", flag.CommandLine.Name(), flags.Name())
+		return 1
+	}
+
+	repositoryConfig := repository.Configuration()
+	secret := repositoryConfig.Encryption
+	for _, name := range flags.Args() {
+		tmp, err := encryption.RemoveKeySlot(secret, name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+			return 1
+		}
+		secret = tmp
+	}
+
+	repositoryConfig.Encryption = secret
+	err := repository.SetConfiguration(repositoryConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s: could not update configuration: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+		return 1
+	}
+	return 0
+}
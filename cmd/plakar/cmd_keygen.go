@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2021 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/poolpOrg/plakar/encryption"
+)
+
+func cmd_keygen(ctx Plakar, args []string) int {
+	var opt_force bool
+
+	flags := flag.NewFlagSet("keygen", flag.ExitOnError)
+	flags.BoolVar(&opt_force, "force", false, "overwrite an existing keypair")
+	flags.Parse(args)
+
+	pathname := ctx.KeypairFile
+	if flags.NArg() == 1 {
+		pathname = flags.Arg(0)
+	} else if flags.NArg() > 1 {
+		fmt.Fprintf(os.Stderr, "%s: %s: too many parameters\n", flag.CommandLine.Name(), flags.Name())
+		return 1
+	}
+
+	if _, err := os.Stat(pathname); err == nil && !opt_force {
+		fmt.Fprintf(os.Stderr, "%s: %s: keypair already exists: %s\n", flag.CommandLine.Name(), flags.Name(), pathname)
+		return 1
+	}
+
+	keypair, err := encryption.Keygen()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+		return 1
+	}
+
+	err = ioutil.WriteFile(pathname, keypair.Serialize(), 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s: could not write keypair: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+		return 1
+	}
+
+	// the public key goes into the trusted keys of verifying hosts
+	fmt.Printf("%s %s\n", base64.StdEncoding.EncodeToString(keypair.PublicKey), ctx.Hostname)
+	return 0
+}
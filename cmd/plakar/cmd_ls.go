@@ -40,14 +40,16 @@ func init() {
 func cmd_ls(ctx Plakar, repository *storage.Repository, args []string) int {
 	var opt_recursive bool
 	var opt_uuid bool
+	var opt_tags arrayFlags
 
 	flags := flag.NewFlagSet("ls", flag.ExitOnError)
 	flags.BoolVar(&opt_uuid, "uuid", false, "display uuid instead of short ID")
 	flags.BoolVar(&opt_recursive, "recursive", false, "recursive listing")
+	flags.Var(&opt_tags, "tag", "only list snapshots with this tag, may be repeated")
 	flags.Parse(args)
 
 	if flags.NArg() == 0 {
-		list_snapshots(repository, opt_uuid)
+		list_snapshots(repository, opt_uuid, opt_tags)
 		return 0
 	}
 
@@ -59,13 +61,16 @@ func cmd_ls(ctx Plakar, repository *storage.Repository, args []string) int {
 	return 0
 }
 
-func list_snapshots(repository *storage.Repository, useUuid bool) {
+func list_snapshots(repository *storage.Repository, useUuid bool, tags []string) {
 	metadatas, err := getMetadatas(repository, nil)
 	if err != nil {
 		log.Fatalf("%s: could not fetch snapshots list", flag.CommandLine.Name())
 	}
 
 	for _, metadata := range metadatas {
+		if !metadata.HasTags(tags) {
+			continue
+		}
 		if !useUuid {
 			fmt.Fprintf(os.Stdout, "%s%10s%10s%10s %s\n",
 				metadata.CreationTime.UTC().Format(time.RFC3339),
@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2021 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/poolpOrg/plakar/encryption"
+	"github.com/poolpOrg/plakar/helpers"
+	"github.com/poolpOrg/plakar/storage"
+)
+
+func init() {
+	registerCommand("passwd", cmd_passwd)
+}
+
+func cmd_passwd(ctx Plakar, repository *storage.Repository, args []string) int {
+	var opt_newkeyfile string
+	var opt_kdf string
+
+	flags := flag.NewFlagSet("passwd", flag.ExitOnError)
+	flags.StringVar(&opt_newkeyfile, "new-keyfile", "", "read new passphrase from key file")
+	flags.StringVar(&opt_kdf, "kdf", encryption.DefaultKDF, fmt.Sprintf("key derivation function (%s)", strings.Join(encryption.KDFs(), ", ")))
+	flags.Parse(args)
+
+	secret := repository.GetSecret()
+	if secret == nil {
+		fmt.Fprintf(os.Stderr, "%s: %s: repository is not encrypted\n", flag.CommandLine.Name(), flags.Name())
+		return 1
+	}
+
+	var passphrase []byte
+	if opt_newkeyfile == "" {
+		for {
+			tmp, err := helpers.GetPassphraseConfirm("new repository")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				continue
+			}
+			passphrase = tmp
+			break
+		}
+	} else {
+		data, err := ioutil.ReadFile(opt_newkeyfile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s: could not read key file: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+			return 1
+		}
+		passphrase = []byte(strings.TrimSuffix(string(data), "\n"))
+	}
+
+	// only the master key is re-wrapped in the slot that was unlocked,
+	// chunks, objects and indexes remain encrypted with the same key and
+	// are left untouched.
+	wrapped, err := encryption.ReplaceKeySlot(repository.Configuration().Encryption, secret, ctx.KeySlot, passphrase, opt_kdf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+		return 1
+	}
+
+	repositoryConfig := repository.Configuration()
+	repositoryConfig.Encryption = wrapped
+	err = repository.SetConfiguration(repositoryConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s: could not update configuration: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+		return 1
+	}
+
+	return 0
+}
@@ -23,6 +23,8 @@ import (
 	"os"
 	"strings"
 
+	"github.com/dustin/go-humanize"
+	"github.com/poolpOrg/plakar/logger"
 	"github.com/poolpOrg/plakar/snapshot"
 	"github.com/poolpOrg/plakar/storage"
 )
@@ -34,6 +36,13 @@ func init() {
 func cmd_pull(ctx Plakar, repository *storage.Repository, args []string) int {
 	var pullPath string
 	var pullRebase bool
+	var pullXattrs bool
+	var pullACLs bool
+	var pullNumericOwner bool
+	var pullDryRun bool
+	var pullOnConflict string
+	var pullIgnoreErrors bool
+	var pullConcurrency int
 
 	dir, err := os.Getwd()
 	if err != nil {
@@ -43,8 +52,39 @@ func cmd_pull(ctx Plakar, repository *storage.Repository, args []string) int {
 	flags := flag.NewFlagSet("pull", flag.ExitOnError)
 	flags.StringVar(&pullPath, "path", dir, "base directory where pull will restore")
 	flags.BoolVar(&pullRebase, "rebase", false, "strip pathname when pulling")
+	flags.BoolVar(&pullXattrs, "xattrs", false, "restore extended attributes")
+	flags.BoolVar(&pullACLs, "acls", false, "restore POSIX ACLs")
+	flags.BoolVar(&pullNumericOwner, "numeric-owner", false, "restore owners by uid/gid instead of user/group names")
+	flags.BoolVar(&pullDryRun, "dry-run", false, "list what would be restored without writing anything")
+	flags.StringVar(&pullOnConflict, "on-conflict", snapshot.ConflictOverwrite, fmt.Sprintf("policy for existing files (%s)", strings.Join(snapshot.ConflictPolicies(), ", ")))
+	flags.BoolVar(&pullIgnoreErrors, "ignore-errors", false, "keep restoring past pathnames that can't be restored")
+	flags.IntVar(&pullConcurrency, "concurrency", ctx.NumCPU*2+1, "number of files and chunks restored concurrently")
 	flags.Parse(args)
 
+	if pullConcurrency < 1 {
+		log.Fatalf("%s: %s: invalid concurrency: %d", flag.CommandLine.Name(), flags.Name(), pullConcurrency)
+	}
+
+	validPolicy := false
+	for _, policy := range snapshot.ConflictPolicies() {
+		if pullOnConflict == policy {
+			validPolicy = true
+		}
+	}
+	if !validPolicy {
+		log.Fatalf("%s: %s: unknown conflict policy: %s", flag.CommandLine.Name(), flags.Name(), pullOnConflict)
+	}
+
+	options := &snapshot.PullOptions{
+		Xattrs:       pullXattrs,
+		ACLs:         pullACLs,
+		NumericOwner: pullNumericOwner,
+		DryRun:       pullDryRun,
+		OnConflict:   pullOnConflict,
+		IgnoreErrors: pullIgnoreErrors,
+		Concurrency:  pullConcurrency,
+	}
+
 	if flags.NArg() == 0 {
 		metadatas, err := getMetadatas(repository, nil)
 		if err != nil {
@@ -59,7 +99,9 @@ func cmd_pull(ctx Plakar, repository *storage.Repository, args []string) int {
 					if err != nil {
 						return 1
 					}
-					snap.Pull(pullPath, true, dir)
+					if !pullReport(snap.Pull(pullPath, true, dir, options)) {
+						return 1
+					}
 					return 0
 				}
 			}
@@ -73,10 +115,38 @@ func cmd_pull(ctx Plakar, repository *storage.Repository, args []string) int {
 		log.Fatal(err)
 	}
 
+	status := 0
 	for offset, snap := range snapshots {
 		_, pattern := parseSnapshotID(flags.Args()[offset])
-		snap.Pull(pullPath, pullRebase, pattern)
+		if !pullReport(snap.Pull(pullPath, pullRebase, pattern, options)) {
+			status = 1
+			if !pullIgnoreErrors {
+				break
+			}
+		}
 	}
 
-	return 0
+	return status
+}
+
+// pullReport outputs the errors and summary of a pull, it returns false
+// if any pathname could not be restored.
+func pullReport(report *snapshot.PullReport, err error) bool {
+	for _, pullError := range report.Errors {
+		if pullError.Corrupted {
+			logger.Error("%s: corrupted: %s", pullError.Pathname, pullError.Err)
+		} else {
+			logger.Error("%s: %s", pullError.Pathname, pullError.Err)
+		}
+	}
+
+	summary := fmt.Sprintf("%d directories, %d files restored (%s), %d skipped, %d failed, %d corrupted",
+		report.Directories, report.Files, humanize.Bytes(report.Size),
+		report.Skipped, report.Failed, report.Corrupted)
+	if err != nil {
+		logger.Error("%s", summary)
+		return false
+	}
+	logger.Info("%s", summary)
+	return true
 }
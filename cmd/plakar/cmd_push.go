@@ -17,10 +17,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/dustin/go-humanize"
+	"github.com/poolpOrg/plakar/filesystem"
 	"github.com/poolpOrg/plakar/logger"
 	"github.com/poolpOrg/plakar/snapshot"
 	"github.com/poolpOrg/plakar/storage"
@@ -31,32 +41,151 @@ func init() {
 }
 
 func cmd_push(ctx Plakar, repository *storage.Repository, args []string) int {
+	var opt_excludes arrayFlags
+	var opt_excludeFrom string
+	var opt_oneFileSystem bool
+	var opt_skipTypes string
+	var opt_maxSize string
+	var opt_xattrs bool
+	var opt_acls bool
+	var opt_resume bool
+	var opt_checkpointInterval time.Duration
+	var opt_tags arrayFlags
+
 	flags := flag.NewFlagSet("push", flag.ExitOnError)
+	flags.Var(&opt_excludes, "exclude", "exclude pathnames matching pattern, may be repeated")
+	flags.StringVar(&opt_excludeFrom, "exclude-from", "", "read exclude patterns from file")
+	flags.BoolVar(&opt_oneFileSystem, "one-file-system", false, "do not cross filesystem boundaries")
+	flags.StringVar(&opt_skipTypes, "skip-types", "", "skip files of these types: socket,fifo,device")
+	flags.StringVar(&opt_maxSize, "max-size", "", "skip files larger than this size")
+	flags.BoolVar(&opt_xattrs, "xattrs", false, "record extended attributes")
+	flags.BoolVar(&opt_acls, "acls", false, "record POSIX ACLs")
+	flags.BoolVar(&opt_resume, "resume", false, "resume the last interrupted push of the same directories")
+	flags.DurationVar(&opt_checkpointInterval, "checkpoint-interval", 5*time.Minute, "interval between checkpoints of the push in progress, 0 to disable")
+	flags.Var(&opt_tags, "tag", "tag the snapshot, or label it with key=value, may be repeated")
 	flags.Parse(args)
 
+	for _, tag := range opt_tags {
+		if err := snapshot.ValidateTag(tag); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+			return 1
+		}
+	}
+
+	excludes := []string(opt_excludes)
+	if opt_excludeFrom != "" {
+		lines, err := filesystem.ReadExcludeFile(opt_excludeFrom)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s: could not read exclude file: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+			return 1
+		}
+		excludes = append(excludes, lines...)
+	}
+	if _, err := filesystem.NewExcludes("/", excludes); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+		return 1
+	}
+
+	var skipModes os.FileMode
+	if opt_skipTypes != "" {
+		for _, fileType := range strings.Split(opt_skipTypes, ",") {
+			switch strings.TrimSpace(fileType) {
+			case "socket":
+				skipModes |= os.ModeSocket
+			case "fifo":
+				skipModes |= os.ModeNamedPipe
+			case "device":
+				skipModes |= os.ModeDevice | os.ModeCharDevice
+			default:
+				fmt.Fprintf(os.Stderr, "%s: %s: unknown file type: %s\n", flag.CommandLine.Name(), flags.Name(), fileType)
+				return 1
+			}
+		}
+	}
+
+	var maxSize uint64
+	if opt_maxSize != "" {
+		size, err := humanize.ParseBytes(opt_maxSize)
+		if err != nil || size == 0 {
+			fmt.Fprintf(os.Stderr, "%s: %s: invalid size: %s\n", flag.CommandLine.Name(), flags.Name(), opt_maxSize)
+			return 1
+		}
+		maxSize = size
+	}
+
 	dir, err := os.Getwd()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		return 1
 	}
 
-	snap, err := snapshot.New(repository)
+	scanDirs := flags.Args()
+	if len(scanDirs) == 0 {
+		scanDirs = []string{dir}
+	}
+
+	// pushes may run concurrently but not along with maintenance that
+	// could remove the data they reference
+	err = repository.Lock(false)
 	if err != nil {
 		logger.Error("%s", err)
 		return 1
 	}
+	defer repository.Unlock()
+
+	var snap *snapshot.Snapshot
+	if opt_resume {
+		snap, err = resumePush(repository, scanDirs)
+		if err != nil {
+			logger.Error("%s", err)
+			return 1
+		}
+		if snap == nil {
+			logger.Info("no interrupted push to resume")
+		} else {
+			logger.Info("resuming snapshot %s", snap.Metadata.Uuid)
+		}
+	}
+	if snap == nil {
+		snap, err = snapshot.New(repository)
+		if err != nil {
+			logger.Error("%s", err)
+			return 1
+		}
+	}
 
 	snap.Metadata.Hostname = ctx.Hostname
 	snap.Metadata.Username = ctx.Username
 	snap.Metadata.MachineID = ctx.MachineID
 	snap.Metadata.CommandLine = ctx.CommandLine
-
-	if flags.NArg() == 0 {
-		err = snap.Push([]string{dir})
-	} else {
-		err = snap.Push(flags.Args())
+	for _, tag := range opt_tags {
+		snap.Metadata.AddTag(tag)
 	}
+	snap.Excludes = excludes
+	snap.OneFileSystem = opt_oneFileSystem
+	snap.SkipModes = skipModes
+	snap.MaxSize = int64(maxSize)
+	snap.Xattrs = opt_xattrs
+	snap.ACLs = opt_acls
+	snap.CheckpointInterval = opt_checkpointInterval
+
+	// the first signal stops the push cleanly, a second one kills it
+	pushCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-pushCtx.Done()
+		stop()
+	}()
 
+	err = snap.Push(pushCtx, scanDirs)
+	if errors.Is(err, context.Canceled) {
+		if opt_checkpointInterval != 0 {
+			logger.Error("push interrupted, run push -resume to resume it")
+		} else {
+			logger.Error("push interrupted")
+		}
+		return 1
+	}
 	if err != nil {
 		logger.Error("%s", err)
 		return 1
@@ -65,3 +194,37 @@ func cmd_push(ctx Plakar, repository *storage.Repository, args []string) int {
 	logger.Info("created snapshot %s", snap.Metadata.Uuid)
 	return 0
 }
+
+// resumePush resumes the most recent interrupted push of the same
+// directories, skipping those still in progress in another process. It
+// returns nil if there is none.
+func resumePush(repository *storage.Repository, scanDirs []string) (*snapshot.Snapshot, error) {
+	directories := make([]string, 0)
+	for _, scanDir := range scanDirs {
+		scanDir, err := filepath.Abs(scanDir)
+		if err != nil {
+			return nil, err
+		}
+		directories = append(directories, filepath.Clean(scanDir))
+	}
+	sort.Strings(directories)
+
+	checkpoints, err := snapshot.ListCheckpoints(repository)
+	if err != nil {
+		return nil, err
+	}
+	for _, metadata := range checkpoints {
+		scanned := append([]string{}, metadata.ScannedDirectories...)
+		sort.Strings(scanned)
+		if strings.Join(scanned, "\x00") != strings.Join(directories, "\x00") {
+			continue
+		}
+		snap, err := snapshot.Resume(repository, metadata.Uuid)
+		if errors.Is(err, storage.ErrTransactionInUse) {
+			logger.Info("%s: in progress, skipping", metadata.Uuid)
+			continue
+		}
+		return snap, err
+	}
+	return nil, nil
+}
@@ -38,6 +38,13 @@ func cmd_rm(ctx Plakar, repository *storage.Repository, args []string) int {
 		log.Fatalf("%s: need at least one snapshot ID to rm", flag.CommandLine.Name())
 	}
 
+	err := repository.Lock(true)
+	if err != nil {
+		logger.Error("%s", err)
+		return 1
+	}
+	defer repository.Unlock()
+
 	snapshots, err := getSnapshots(repository, flags.Args())
 	if err != nil {
 		log.Fatal(err)
@@ -75,6 +75,13 @@ func cmd_sync(ctx Plakar, repository *storage.Repository, args []string) int {
 			return 1
 		}
 
+		err = syncRepository.Lock(false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: could not lock repository: %s\n", repository, err)
+			return 1
+		}
+		defer syncRepository.Unlock()
+
 		destChunkChecksums, err := syncRepository.GetChunks()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s: could not get chunks list from repository: %s\n", ctx.Repository, err)
@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2021 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/poolpOrg/plakar/logger"
+	"github.com/poolpOrg/plakar/snapshot"
+	"github.com/poolpOrg/plakar/storage"
+)
+
+func init() {
+	registerCommand("tag", cmd_tag)
+}
+
+func cmd_tag(ctx Plakar, repository *storage.Repository, args []string) int {
+	flags := flag.NewFlagSet("tag", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() < 3 || (flags.Arg(0) != "add" && flags.Arg(0) != "rm") {
+		fmt.Fprintf(os.Stderr, "usage: %s tag add|rm tag snapshot...\n", flag.CommandLine.Name())
+		return 1
+	}
+	action, tag := flags.Arg(0), flags.Arg(1)
+
+	err := snapshot.ValidateTag(tag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+		return 1
+	}
+
+	metadatas, err := getMetadatas(repository, flags.Args()[2:])
+	if err != nil {
+		logger.Error("%s", err)
+		return 1
+	}
+
+	failures := 0
+	for _, metadata := range metadatas {
+		// rewriting an untrusted snapshot would sign it with a trusted key
+		metadata, verified, err := snapshot.GetMetadata(repository, metadata.Uuid)
+		if err == nil {
+			err = snapshot.VerifyTrust(repository, metadata, verified)
+		}
+		if err != nil {
+			logger.Error("%s", err)
+			failures++
+			continue
+		}
+
+		var changed bool
+		if action == "add" {
+			changed = metadata.AddTag(tag)
+		} else {
+			changed = metadata.RemoveTag(tag)
+		}
+		if !changed {
+			continue
+		}
+
+		err = snapshot.UpdateMetadata(repository, metadata, verified)
+		if err != nil {
+			logger.Error("%s", err)
+			failures++
+			continue
+		}
+		logger.Info("%s: tags: %s", metadata.Uuid, strings.Join(metadata.Tags, ", "))
+	}
+
+	if failures != 0 {
+		return 1
+	}
+	return 0
+}
@@ -24,6 +24,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -77,23 +78,78 @@ func cmd_tarball(ctx Plakar, repository *storage.Repository, args []string) int
 	for offset, snapshot := range snapshots {
 		_, prefix := parseSnapshotID(flags.Args()[offset])
 
+		isWithin := func(file string) bool {
+			return prefix == "" || helpers.PathIsWithin(file, prefix)
+		}
+		entryName := func(file string) string {
+			if tarballRebase {
+				return strings.TrimPrefix(file, prefix)
+			}
+			return file
+		}
+
+		// sorted so that the first pathname of a hard link group is
+		// archived before the links referring to it
+		files := make([]string, 0, len(snapshot.Index.Pathnames))
 		for file := range snapshot.Index.Pathnames {
-			if prefix != "" {
-				if !helpers.PathIsWithin(file, prefix) {
-					continue
-				}
+			files = append(files, file)
+		}
+		files = append(files, snapshot.Index.Filesystem.ListNonRegular()...)
+		sort.Strings(files)
+
+		for _, file := range files {
+			if !isWithin(file) {
+				continue
 			}
 
 			info, _ := snapshot.LookupInodeForPathname(file)
-			filepath := file
-			if tarballRebase {
-				filepath = strings.TrimPrefix(filepath, prefix)
-			}
 			header := &tar.Header{
-				Name:    filepath,
+				Name:    entryName(file),
 				Size:    info.Size,
 				Mode:    int64(info.Mode),
 				ModTime: info.ModTime,
+				Uid:     int(info.Uid),
+				Gid:     int(info.Gid),
+				Uname:   info.Username,
+				Gname:   info.Groupname,
+			}
+
+			if !info.Mode.IsRegular() {
+				header.Mode = int64(info.Mode.Perm())
+				header.Size = 0
+				switch {
+				case info.Mode&os.ModeSymlink != 0:
+					header.Typeflag = tar.TypeSymlink
+					header.Linkname, _ = snapshot.LookupSymlink(file)
+				case info.Mode&os.ModeNamedPipe != 0:
+					header.Typeflag = tar.TypeFifo
+				case info.Mode&os.ModeCharDevice != 0:
+					header.Typeflag = tar.TypeChar
+				case info.Mode&os.ModeDevice != 0:
+					header.Typeflag = tar.TypeBlock
+				default:
+					// sockets can't be archived
+					continue
+				}
+				if info.IsDevice() {
+					major, minor := info.DeviceNumbers()
+					header.Devmajor = int64(major)
+					header.Devminor = int64(minor)
+				}
+				if err := tarWriter.WriteHeader(header); err != nil {
+					logger.Error("could not write header for file %s", file)
+				}
+				continue
+			}
+
+			if first, isLink := snapshot.LookupHardLink(file); isLink && isWithin(first) {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = entryName(first)
+				header.Size = 0
+				if err := tarWriter.WriteHeader(header); err != nil {
+					logger.Error("could not write header for file %s", file)
+				}
+				continue
 			}
 
 			err = tarWriter.WriteHeader(header)
@@ -37,6 +37,8 @@ type Plakar struct {
 	Cache *cache.Cache
 
 	KeyFromFile string
+	KeySlot     string
+	KeypairFile string
 }
 
 var commands map[string]func(Plakar, *storage.Repository, []string) int = make(map[string]func(Plakar, *storage.Repository, []string) int)
@@ -84,6 +86,8 @@ func entryPoint() int {
 	opt_usernameDefault := opt_userDefault.Username
 	opt_repositoryDefault := path.Join(opt_userDefault.HomeDir, ".plakar")
 	opt_cacheDefault := path.Join(opt_userDefault.HomeDir, ".plakar-cache")
+	opt_keypairDefault := path.Join(opt_userDefault.HomeDir, ".plakar-keypair")
+	opt_trustedKeysDefault := path.Join(opt_userDefault.HomeDir, ".plakar-trusted-keys")
 
 	// command line overrides
 	var opt_cpuCount int
@@ -98,6 +102,9 @@ func entryPoint() int {
 	var opt_verbose bool
 	var opt_profiling bool
 	var opt_keyfile string
+	var opt_keypair string
+	var opt_trustedKeys string
+	var opt_writeOnly bool
 
 	flag.StringVar(&opt_cachedir, "cache", opt_cacheDefault, "default cache directory")
 	flag.IntVar(&opt_cpuCount, "cpu", opt_cpuDefault, "limit the number of usable cores")
@@ -111,6 +118,9 @@ func entryPoint() int {
 	flag.BoolVar(&opt_verbose, "verbose", false, "display verbose logs")
 	flag.BoolVar(&opt_profiling, "profiling", false, "display profiling logs")
 	flag.StringVar(&opt_keyfile, "keyfile", "", "use passphrase from key file when prompted")
+	flag.StringVar(&opt_keypair, "keypair", opt_keypairDefault, "keypair used to sign snapshots")
+	flag.BoolVar(&opt_writeOnly, "write-only", false, "push using the repository public key, without the passphrase")
+	flag.StringVar(&opt_trustedKeys, "trusted-keys", opt_trustedKeysDefault, "public keys trusted to sign snapshots")
 	flag.Parse()
 
 	// setup from default + override
@@ -152,6 +162,7 @@ func entryPoint() int {
 	ctx.CommandLine = strings.Join(os.Args, " ")
 	ctx.MachineID = opt_machineIdDefault
 	ctx.KeyFromFile = secretFromKeyfile
+	ctx.KeypairFile = opt_keypair
 
 	if flag.NArg() == 0 {
 		fmt.Fprintf(os.Stderr, "%s: a command must be provided\n", flag.CommandLine.Name())
@@ -192,10 +203,33 @@ func entryPoint() int {
 		return cmd_create(ctx, args)
 	}
 
+	if command == "keygen" {
+		return cmd_keygen(ctx, args)
+	}
+
 	if command == "stdio" {
 		return cmd_stdio(ctx, args)
 	}
 
+	// the default keypair and trusted keys are optional
+	var keypair *encryption.Keypair
+	if _, err := os.Stat(opt_keypair); err == nil || opt_keypair != opt_keypairDefault {
+		keypair, err = encryption.KeypairFromFile(opt_keypair)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: could not load keypair: %s\n", flag.CommandLine.Name(), err)
+			return 1
+		}
+	}
+
+	var trustedKeys [][]byte
+	if _, err := os.Stat(opt_trustedKeys); err == nil || opt_trustedKeys != opt_trustedKeysDefault {
+		trustedKeys, err = encryption.TrustedKeysFromFile(opt_trustedKeys)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: could not load trusted keys: %s\n", flag.CommandLine.Name(), err)
+			return 1
+		}
+	}
+
 	repository, err := storage.Open(ctx.Repository)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s: %s\n", flag.CommandLine.Name(), err)
@@ -203,7 +237,23 @@ func entryPoint() int {
 	}
 
 	var secret []byte
-	if repository.Configuration().Encryption != "" {
+	var publicKey []byte
+	if opt_writeOnly {
+		if command != "push" {
+			fmt.Fprintf(os.Stderr, "%s: %s: not available in write-only mode\n", flag.CommandLine.Name(), command)
+			return 1
+		}
+		if repository.Configuration().Encryption == "" {
+			fmt.Fprintf(os.Stderr, "%s: write-only mode requires an encrypted repository\n", flag.CommandLine.Name())
+			return 1
+		}
+		publicKey, err = encryption.SecretPublicKey(repository.Configuration().Encryption)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", flag.CommandLine.Name(), err)
+			return 1
+		}
+	} else if repository.Configuration().Encryption != "" {
+		var slot *encryption.KeySlot
 		if ctx.KeyFromFile == "" {
 			for {
 				passphrase, err := helpers.GetPassphrase("repository")
@@ -212,7 +262,7 @@ func entryPoint() int {
 					continue
 				}
 
-				secret, err = encryption.DeriveSecret(passphrase, repository.Configuration().Encryption)
+				secret, slot, err = encryption.UnlockSecret(passphrase, repository.Configuration().Encryption)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "%s\n", err)
 					continue
@@ -221,16 +271,24 @@ func entryPoint() int {
 				break
 			}
 		} else {
-			secret, err = encryption.DeriveSecret([]byte(ctx.KeyFromFile), repository.Configuration().Encryption)
+			secret, slot, err = encryption.UnlockSecret([]byte(ctx.KeyFromFile), repository.Configuration().Encryption)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%s\n", err)
 				os.Exit(1)
 			}
 		}
+
+		ctx.KeySlot = slot.Name
+		if slot.KDF.IsLegacy() && command != "passwd" {
+			logger.Warn("repository passphrase uses a weak key derivation, run `plakar passwd` to upgrade it")
+		}
 	}
 
 	//
 	repository.SetSecret(secret)
+	repository.SetPublicKey(publicKey)
+	repository.SetKeypair(keypair)
+	repository.SetTrustedKeys(trustedKeys)
 	repository.SetCache(ctx.Cache)
 	repository.SetUsername(ctx.Username)
 	repository.SetHostname(ctx.Hostname)
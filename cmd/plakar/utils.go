@@ -28,6 +28,18 @@ import (
 	"github.com/poolpOrg/plakar/storage"
 )
 
+// arrayFlags collects the values of a repeated command line option.
+type arrayFlags []string
+
+func (list *arrayFlags) String() string {
+	return strings.Join(*list, ", ")
+}
+
+func (list *arrayFlags) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
 func parseSnapshotID(id string) (string, string) {
 	tmp := strings.Split(id, ":")
 	prefix := id
@@ -18,28 +18,124 @@ package compression
 
 import (
 	"bytes"
-	"compress/gzip"
-	"io/ioutil"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
 )
 
-func Deflate(buf []byte) []byte {
-	var b bytes.Buffer
-	w := gzip.NewWriter(&b)
-	w.Write(buf)
-	w.Close()
-	return b.Bytes()
+// every blob produced by Deflate starts with this header, the last byte
+// identifies the codec that was used so that readers do not depend on
+// the repository configuration to decode it.
+var headerMagic = []byte{'p', 'l', 'k'}
+
+const headerSize = 4
+
+// blobs written before the header was introduced are raw gzip streams
+var gzipMagic = []byte{0x1f, 0x8b}
+
+type Codec interface {
+	Deflate(buf []byte) ([]byte, error)
+	Inflate(buf []byte) ([]byte, error)
 }
 
-func Inflate(buf []byte) ([]byte, error) {
-	w, err := gzip.NewReader(bytes.NewBuffer(buf))
-	if err != nil {
-		return nil, err
+type codecEntry struct {
+	name  string
+	id    byte
+	codec Codec
+}
+
+var muCodecs sync.Mutex
+var codecsByName map[string]*codecEntry = make(map[string]*codecEntry)
+var codecsByID map[byte]*codecEntry = make(map[byte]*codecEntry)
+
+func Register(name string, id byte, codec Codec) {
+	muCodecs.Lock()
+	defer muCodecs.Unlock()
+
+	if _, ok := codecsByName[name]; ok {
+		log.Fatalf("codec '%s' registered twice", name)
+	}
+	if _, ok := codecsByID[id]; ok {
+		log.Fatalf("codec id '%d' registered twice", id)
+	}
+	entry := &codecEntry{name: name, id: id, codec: codec}
+	codecsByName[name] = entry
+	codecsByID[id] = entry
+}
+
+func Codecs() []string {
+	muCodecs.Lock()
+	defer muCodecs.Unlock()
+
+	ret := make([]string, 0)
+	for name := range codecsByName {
+		ret = append(ret, name)
 	}
-	defer w.Close()
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i] < ret[j]
+	})
+	return ret
+}
+
+func Exists(name string) bool {
+	muCodecs.Lock()
+	defer muCodecs.Unlock()
 
-	data, err := ioutil.ReadAll(w)
+	_, exists := codecsByName[name]
+	return exists
+}
+
+func Deflate(name string, buf []byte) ([]byte, error) {
+	muCodecs.Lock()
+	entry, exists := codecsByName[name]
+	muCodecs.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("unsupported compression codec: %s", name)
+	}
+
+	data, err := entry.codec.Deflate(buf)
 	if err != nil {
 		return nil, err
 	}
-	return data, nil
+
+	ret := make([]byte, 0, headerSize+len(data))
+	ret = append(ret, headerMagic...)
+	ret = append(ret, entry.id)
+	return append(ret, data...), nil
+}
+
+func Inflate(buf []byte) ([]byte, error) {
+	if !bytes.HasPrefix(buf, headerMagic) || len(buf) < headerSize {
+		if bytes.HasPrefix(buf, gzipMagic) {
+			return gzipInflate(buf)
+		}
+		return nil, fmt.Errorf("invalid compression header")
+	}
+
+	muCodecs.Lock()
+	entry, exists := codecsByID[buf[len(headerMagic)]]
+	muCodecs.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("unsupported compression codec id: %d", buf[len(headerMagic)])
+	}
+	return entry.codec.Inflate(buf[headerSize:])
+}
+
+// CodecOf returns the name of the codec used to produce a blob
+func CodecOf(buf []byte) (string, error) {
+	if !bytes.HasPrefix(buf, headerMagic) || len(buf) < headerSize {
+		if bytes.HasPrefix(buf, gzipMagic) {
+			return "gzip", nil
+		}
+		return "", fmt.Errorf("invalid compression header")
+	}
+
+	muCodecs.Lock()
+	entry, exists := codecsByID[buf[len(headerMagic)]]
+	muCodecs.Unlock()
+	if !exists {
+		return "", fmt.Errorf("unsupported compression codec id: %d", buf[len(headerMagic)])
+	}
+	return entry.name, nil
 }
@@ -17,7 +17,8 @@
 package compression
 
 import (
-	"fmt"
+	"bytes"
+	"compress/gzip"
 	"math/rand"
 	"testing"
 )
@@ -25,10 +26,79 @@ import (
 func TestCompression(t *testing.T) {
 	token := make([]byte, 65*1024)
 	rand.Read(token)
-	deflated := Deflate(token)
-	fmt.Println(deflated)
-	inflated, err := Inflate(deflated)
+
+	for _, codec := range Codecs() {
+		deflated, err := Deflate(codec, token)
+		if err != nil {
+			t.Errorf("Deflate(%s): %s", codec, err)
+			continue
+		}
+
+		name, err := CodecOf(deflated)
+		if err != nil || name != codec {
+			t.Errorf("CodecOf(Deflate(%s)) = %q, %v", codec, name, err)
+		}
+
+		inflated, err := Inflate(deflated)
+		if err != nil {
+			t.Errorf("Inflate(Deflate(%s)): %s", codec, err)
+			continue
+		}
+		if !bytes.Equal(inflated, token) {
+			t.Errorf("Inflate(Deflate(%s)) != token", codec)
+		}
+	}
+}
+
+func TestCompressionLegacy(t *testing.T) {
+	token := make([]byte, 65*1024)
+	rand.Read(token)
+
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write(token)
+	w.Close()
+
+	inflated, err := Inflate(b.Bytes())
 	if err != nil {
-		t.Errorf("Inflate(Deflate(%q)) != %q", inflated, token)
+		t.Errorf("Inflate(legacy): %s", err)
+	}
+	if !bytes.Equal(inflated, token) {
+		t.Errorf("Inflate(legacy) != token")
+	}
+}
+
+func TestCompressionUnknown(t *testing.T) {
+	if _, err := Deflate("unknown", []byte("plakar")); err == nil {
+		t.Errorf("Deflate(unknown) should fail")
+	}
+	if _, err := Inflate([]byte("plakar")); err == nil {
+		t.Errorf("Inflate(garbage) should fail")
+	}
+}
+
+func TestContentType(t *testing.T) {
+	for _, contentType := range []string{"image/jpeg", "video/mp4", "application/zip", "Application/GZIP; charset=binary"} {
+		if !IsCompressedContentType(contentType) {
+			t.Errorf("IsCompressedContentType(%q) should be true", contentType)
+		}
+	}
+	for _, contentType := range []string{"", "text/plain; charset=utf-8", "image/bmp", "application/json"} {
+		if IsCompressedContentType(contentType) {
+			t.Errorf("IsCompressedContentType(%q) should be false", contentType)
+		}
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	random := make([]byte, 65*1024)
+	rand.Read(random)
+	if IsCompressible(random) {
+		t.Errorf("IsCompressible(random) should be false")
+	}
+
+	text := bytes.Repeat([]byte("plakar is a backup tool. "), 4096)
+	if !IsCompressible(text) {
+		t.Errorf("IsCompressible(text) should be true")
 	}
 }
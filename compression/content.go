@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2021 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package compression
+
+import (
+	"math"
+	"strings"
+)
+
+// content types whose payload is already compressed, deflating them
+// only burns CPU and usually produces a slightly larger output.
+var compressedContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+	"image/heic": true,
+	"image/heif": true,
+	"image/avif": true,
+	"image/jp2":  true,
+
+	"audio/mpeg": true,
+	"audio/aac":  true,
+	"audio/ogg":  true,
+	"audio/flac": true,
+	"audio/mp4":  true,
+	"audio/webm": true,
+	"audio/opus": true,
+
+	"application/zip":              true,
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/zstd":             true,
+	"application/x-lz4":            true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/vnd.rar":          true,
+	"application/java-archive":     true,
+	"application/epub+zip":         true,
+	"application/x-compress":       true,
+
+	"font/woff":  true,
+	"font/woff2": true,
+}
+
+var compressedContentTypePrefixes = []string{
+	"video/",
+	"application/vnd.openxmlformats-officedocument.",
+	"application/vnd.oasis.opendocument.",
+}
+
+// above this many bits of entropy per byte, a sample is considered
+// random enough that compression will not help.
+const entropyThreshold = 7.5
+
+// size of the sample used by the entropy probe
+const entropySampleSize = 4096
+
+// IsCompressedContentType reports whether the content type describes
+// data that is already compressed.
+func IsCompressedContentType(contentType string) bool {
+	contentType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if compressedContentTypes[contentType] {
+		return true
+	}
+	for _, prefix := range compressedContentTypePrefixes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Entropy returns the Shannon entropy of buf in bits per byte.
+func Entropy(buf []byte) float64 {
+	if len(buf) == 0 {
+		return 0
+	}
+
+	var frequencies [256]uint64
+	for _, b := range buf {
+		frequencies[b]++
+	}
+
+	entropy := 0.0
+	size := float64(len(buf))
+	for _, count := range frequencies {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / size
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// IsCompressible is a quick probe that samples the head, middle and tail
+// of buf and reports whether compressing it is likely to be worth it.
+func IsCompressible(buf []byte) bool {
+	if len(buf) <= entropySampleSize {
+		return Entropy(buf) < entropyThreshold
+	}
+
+	third := entropySampleSize / 3
+	middle := len(buf) / 2
+	sample := make([]byte, 0, entropySampleSize)
+	sample = append(sample, buf[:third]...)
+	sample = append(sample, buf[middle-third/2:middle+third/2]...)
+	sample = append(sample, buf[len(buf)-third:]...)
+	return Entropy(sample) < entropyThreshold
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright (c) 2021 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package compression
+
+import (
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstd encoders and decoders are safe for concurrent use through
+// EncodeAll and DecodeAll, a single instance is shared.
+type zstdCodec struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func init() {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		panic(err)
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		panic(err)
+	}
+	Register("zstd", 2, &zstdCodec{encoder: encoder, decoder: decoder})
+}
+
+func (codec *zstdCodec) Deflate(buf []byte) ([]byte, error) {
+	return codec.encoder.EncodeAll(buf, make([]byte, 0, len(buf))), nil
+}
+
+func (codec *zstdCodec) Inflate(buf []byte) ([]byte, error) {
+	return codec.decoder.DecodeAll(buf, nil)
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2021 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package encryption
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// In write-only mode, hosts pushing snapshots only know the repository
+// public key. The per-blob subkey is wrapped with a key agreed between
+// an ephemeral X25519 key and the repository key:
+//
+//	magic | version | algorithm | ephemeral public key | wrapped subkey | nonce | ciphertext
+//
+// The repository X25519 private key is derived from the master key, so
+// any passphrase unlocking the repository can also read these blobs.
+
+func writeOnlyPrivateKey(masterKey []byte) ([]byte, error) {
+	privateKey := make([]byte, curve25519.ScalarSize)
+	_, err := io.ReadFull(hkdf.New(sha256.New, masterKey, nil, []byte("plakar write-only key")), privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return privateKey, nil
+}
+
+// PublicKey returns the repository public key used in write-only mode.
+func PublicKey(masterKey []byte) ([]byte, error) {
+	privateKey, err := writeOnlyPrivateKey(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return curve25519.X25519(privateKey, curve25519.Basepoint)
+}
+
+func agreedKey(privateKey []byte, peerKey []byte, ephemeralKey []byte, publicKey []byte) ([]byte, error) {
+	shared, err := curve25519.X25519(privateKey, peerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := append(append([]byte{}, ephemeralKey...), publicKey...)
+	key := make([]byte, 32)
+	_, err = io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("plakar subkey")), key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// EncryptToPublicKey seals a blob so that it can only be decrypted with
+// the master key matching publicKey.
+func EncryptToPublicKey(publicKey []byte, buf []byte, associatedData []byte) ([]byte, error) {
+	if len(publicKey) != curve25519.PointSize {
+		return nil, fmt.Errorf("invalid public key")
+	}
+
+	ephemeralPrivateKey := make([]byte, curve25519.ScalarSize)
+	rand.Read(ephemeralPrivateKey)
+	ephemeralKey, err := curve25519.X25519(ephemeralPrivateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	kek, err := agreedKey(ephemeralPrivateKey, publicKey, ephemeralKey, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	subkey := make([]byte, 32)
+	rand.Read(subkey)
+
+	header := envelopeHeader(algorithmX25519AES256GCM)
+	envelope, err := wrapSubkey(append(header, ephemeralKey...), kek, subkey, header)
+	if err != nil {
+		return nil, err
+	}
+	return sealPayload(envelope, subkey, buf, header, associatedData)
+}
+
+func unwrapSealedSubkey(masterKey []byte, buf []byte, header []byte) ([]byte, []byte, error) {
+	if len(buf) < curve25519.PointSize {
+		return nil, nil, fmt.Errorf("invalid encrypted blob")
+	}
+	ephemeralKey, buf := buf[:curve25519.PointSize], buf[curve25519.PointSize:]
+
+	privateKey, err := writeOnlyPrivateKey(masterKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kek, err := agreedKey(privateKey, ephemeralKey, ephemeralKey, publicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return unwrapSubkey(kek, buf, header)
+}
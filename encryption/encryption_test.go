@@ -18,8 +18,18 @@ package encryption
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"os"
 	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
 )
 
 func TestEncryption(t *testing.T) {
@@ -29,12 +39,12 @@ func TestEncryption(t *testing.T) {
 	buffer := make([]byte, 65*1024)
 	rand.Read(buffer)
 
-	encrypted, err := Encrypt(key, buffer)
+	encrypted, err := Encrypt(key, buffer, AssociatedData("chunk", "checksum"))
 	if err != nil {
 		t.Error(err)
 	}
 
-	decrypted, err := Decrypt(key, encrypted)
+	decrypted, err := Decrypt(key, encrypted, AssociatedData("chunk", "checksum"))
 	if err != nil {
 		t.Error(err)
 	}
@@ -42,4 +52,361 @@ func TestEncryption(t *testing.T) {
 	if !bytes.Equal(decrypted, buffer) {
 		t.Errorf("Decrypt(Encrypt(buffer)) != buffer")
 	}
+
+	if _, err := Decrypt(key, encrypted, AssociatedData("object", "checksum")); err == nil {
+		t.Errorf("Decrypt() should fail with a different blob type")
+	}
+	if _, err := Decrypt(key, encrypted, AssociatedData("chunk", "other")); err == nil {
+		t.Errorf("Decrypt() should fail with a different checksum")
+	}
+
+	encrypted[envelopeHeaderSize] ^= 0xff
+	if _, err := Decrypt(key, encrypted, AssociatedData("chunk", "checksum")); err == nil {
+		t.Errorf("Decrypt() should fail with a tampered wrapped key")
+	}
+}
+
+func TestEncryptionLegacy(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	buffer := make([]byte, 65*1024)
+	rand.Read(buffer)
+
+	// blob layout used before the envelope format
+	subkey := make([]byte, 32)
+	rand.Read(subkey)
+	ecb, _ := aes.NewCipher(key)
+	encsubkey := make([]byte, 32)
+	ecb.Encrypt(encsubkey[:16], subkey[:16])
+	ecb.Encrypt(encsubkey[16:], subkey[16:])
+	block, _ := aes.NewCipher(subkey)
+	aesGCM, _ := cipher.NewGCM(block)
+	nonce := make([]byte, aesGCM.NonceSize())
+	rand.Read(nonce)
+	encrypted := append(encsubkey, aesGCM.Seal(nonce, nonce, buffer, nil)...)
+
+	decrypted, err := Decrypt(key, encrypted, AssociatedData("chunk", "checksum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, buffer) {
+		t.Errorf("Decrypt(legacy) != buffer")
+	}
+
+	// a legacy blob whose wrapped subkey looks like an envelope header is
+	// not retried in the legacy format once the envelope fails to open
+	copy(encsubkey, envelopeHeader(algorithmAES256GCM))
+	ecb.Decrypt(subkey[:16], encsubkey[:16])
+	block, _ = aes.NewCipher(subkey)
+	aesGCM, _ = cipher.NewGCM(block)
+	encrypted = append(encsubkey, aesGCM.Seal(nonce, nonce, buffer, nil)...)
+
+	if decrypted, err := decryptLegacy(key, encrypted); err != nil || !bytes.Equal(decrypted, buffer) {
+		t.Fatalf("decryptLegacy() failed on a valid legacy blob: %v", err)
+	}
+	if _, err := Decrypt(key, encrypted, AssociatedData("chunk", "checksum")); err == nil {
+		t.Errorf("Decrypt() should not fall back to the legacy format")
+	}
+}
+
+func TestSecret(t *testing.T) {
+	secret, masterKey, err := BuildSecretFromPassphrase([]byte("old passphrase"), DefaultKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := DeriveSecret([]byte("old passphrase"), secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, masterKey) {
+		t.Errorf("DeriveSecret() != master key")
+	}
+
+	if _, err := DeriveSecret([]byte("wrong passphrase"), secret); err == nil {
+		t.Errorf("DeriveSecret() should fail with a wrong passphrase")
+	}
+
+	rewrapped, err := ReplaceKeySlot(secret, masterKey, DefaultKeySlot, []byte("new passphrase"), "scrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DeriveSecret([]byte("old passphrase"), rewrapped); err == nil {
+		t.Errorf("DeriveSecret() should fail with the old passphrase")
+	}
+
+	key, err = DeriveSecret([]byte("new passphrase"), rewrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, masterKey) {
+		t.Errorf("master key changed after ReplaceKeySlot()")
+	}
+}
+
+func TestKeySlots(t *testing.T) {
+	secret, masterKey, err := BuildSecretFromPassphrase([]byte("alice"), "scrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recoveryKey := NewRecoveryKey()
+	secret, err = AddKeySlot(secret, masterKey, RecoveryKeySlot, []byte(recoveryKey), "scrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err = AddKeySlot(secret, masterKey, "bob", []byte("bob"), "scrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddKeySlot(secret, masterKey, "bob", []byte("bob"), "scrypt"); err == nil {
+		t.Errorf("AddKeySlot() should fail with a duplicate name")
+	}
+
+	for passphrase, name := range map[string]string{"alice": DefaultKeySlot, recoveryKey: RecoveryKeySlot, "bob": "bob"} {
+		key, slot, err := UnlockSecret([]byte(passphrase), secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(key, masterKey) {
+			t.Errorf("%s: UnlockSecret() != master key", name)
+		}
+		if slot.Name != name {
+			t.Errorf("UnlockSecret() unlocked slot %s, expected %s", slot.Name, name)
+		}
+	}
+
+	secret, err = RemoveKeySlot(secret, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DeriveSecret([]byte("bob"), secret); err == nil {
+		t.Errorf("DeriveSecret() should fail with a removed slot")
+	}
+
+	secret, err = RemoveKeySlot(secret, RecoveryKeySlot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RemoveKeySlot(secret, DefaultKeySlot); err == nil {
+		t.Errorf("RemoveKeySlot() should not remove the last slot")
+	}
+	if _, err := RemoveKeySlot(secret, "unknown"); err == nil {
+		t.Errorf("RemoveKeySlot() should fail with an unknown slot")
+	}
+}
+
+func TestKDF(t *testing.T) {
+	for _, algorithm := range KDFs() {
+		kdf, err := NewKDF(algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key1, err := kdf.DeriveKey([]byte("passphrase"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		key2, err := kdf.DeriveKey([]byte("passphrase"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		key3, err := kdf.DeriveKey([]byte("wrong passphrase"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(key1) != 32 || !bytes.Equal(key1, key2) {
+			t.Errorf("%s: DeriveKey() is not deterministic", algorithm)
+		}
+		if bytes.Equal(key1, key3) {
+			t.Errorf("%s: DeriveKey() ignores the passphrase", algorithm)
+		}
+	}
+
+	if _, err := NewKDF("unknown"); err == nil {
+		t.Errorf("NewKDF() should fail with an unknown algorithm")
+	}
+}
+
+func TestSecretVersion1(t *testing.T) {
+	masterKey := make([]byte, 32)
+	rand.Read(masterKey)
+	salt := make([]byte, 16)
+	rand.Read(salt)
+
+	key, err := sealKey(pbkdf2.Key([]byte("passphrase"), salt, 4096, 32, sha256.New), masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialized, _ := json.Marshal(&wrappedSecret{Version: 1, Salt: salt, Key: key})
+	secret := base64.StdEncoding.EncodeToString(serialized)
+
+	unwrapped, err := DeriveSecret([]byte("passphrase"), secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrapped, masterKey) {
+		t.Errorf("DeriveSecret() != version 1 master key")
+	}
+
+	_, slot, err := UnlockSecret([]byte("passphrase"), secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slot.KDF.IsLegacy() {
+		t.Errorf("version 1 secret should be reported as legacy")
+	}
+}
+
+func TestLegacySecret(t *testing.T) {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	dk := pbkdf2.Key([]byte("passphrase"), salt, 4096, 32, sha256.New)
+	sum := sha256.Sum256(dk)
+	secret := base64.StdEncoding.EncodeToString(append(salt, sum[:]...))
+
+	key, err := DeriveSecret([]byte("passphrase"), secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, dk) {
+		t.Errorf("DeriveSecret() != legacy derived key")
+	}
+
+	if _, err := DeriveSecret([]byte("wrong passphrase"), secret); err == nil {
+		t.Errorf("DeriveSecret() should fail with a wrong passphrase")
+	}
+
+	secret, err = AddKeySlot(secret, dk, "new", []byte("new passphrase"), "scrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, passphrase := range []string{"passphrase", "new passphrase"} {
+		key, err := DeriveSecret([]byte(passphrase), secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(key, dk) {
+			t.Errorf("DeriveSecret() != legacy derived key after AddKeySlot()")
+		}
+	}
+}
+
+func TestKeypair(t *testing.T) {
+	keypair, err := Keygen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("metadata")
+	signature := keypair.Sign(data)
+	if !Verify(keypair.PublicKey, data, signature) {
+		t.Errorf("Verify() failed on a valid signature")
+	}
+	if Verify(keypair.PublicKey, []byte("tampered"), signature) {
+		t.Errorf("Verify() succeeded on tampered data")
+	}
+
+	loaded, err := KeypairFromBytes(keypair.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.PublicKey, keypair.PublicKey) || !bytes.Equal(loaded.PrivateKey, keypair.PrivateKey) {
+		t.Errorf("KeypairFromBytes(Serialize()) != keypair")
+	}
+
+	if _, err := KeypairFromBytes([]byte("invalid")); err == nil {
+		t.Errorf("KeypairFromBytes() should fail with an invalid key")
+	}
+}
+
+func TestTrustedKeys(t *testing.T) {
+	keypair, err := Keygen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpfile, err := ioutil.TempFile("", "plakar-trusted-keys-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	fmt.Fprintf(tmpfile, "# backup hosts\n\n%s host.example.com\n", base64.StdEncoding.EncodeToString(keypair.PublicKey))
+	tmpfile.Close()
+
+	keys, err := TrustedKeysFromFile(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !bytes.Equal(keys[0], keypair.PublicKey) {
+		t.Errorf("TrustedKeysFromFile() did not return the trusted key")
+	}
+}
+
+func TestEncryptionPublicKey(t *testing.T) {
+	masterKey := make([]byte, 32)
+	rand.Read(masterKey)
+
+	buffer := make([]byte, 65*1024)
+	rand.Read(buffer)
+
+	publicKey, err := PublicKey(masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := EncryptToPublicKey(publicKey, buffer, AssociatedData("chunk", "checksum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := Decrypt(masterKey, encrypted, AssociatedData("chunk", "checksum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, buffer) {
+		t.Errorf("Decrypt(EncryptToPublicKey(buffer)) != buffer")
+	}
+
+	if _, err := Decrypt(masterKey, encrypted, AssociatedData("object", "checksum")); err == nil {
+		t.Errorf("Decrypt() should fail with a different blob type")
+	}
+
+	otherKey := make([]byte, 32)
+	rand.Read(otherKey)
+	if _, err := Decrypt(otherKey, encrypted, AssociatedData("chunk", "checksum")); err == nil {
+		t.Errorf("Decrypt() should fail with another master key")
+	}
+}
+
+func TestSecretPublicKey(t *testing.T) {
+	secret, masterKey, err := BuildSecretFromPassphrase([]byte("passphrase"), "scrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := SecretPublicKey(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := PublicKey(masterKey)
+	if !bytes.Equal(publicKey, expected) {
+		t.Errorf("SecretPublicKey() != PublicKey(master key)")
+	}
+
+	secret, err = AddKeySlot(secret, masterKey, "other", []byte("other"), "scrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err = RemoveKeySlot(secret, DefaultKeySlot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if publicKey, err = SecretPublicKey(secret); err != nil || !bytes.Equal(publicKey, expected) {
+		t.Errorf("public key not preserved across key slot changes")
+	}
 }
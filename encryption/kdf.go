@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2021 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package encryption
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const DefaultKDF = "argon2id"
+
+// KDF describes how a key is derived from a passphrase, it is stored
+// alongside the wrapped master key so that parameters can be raised for
+// new repositories without breaking existing ones.
+type KDF struct {
+	Algorithm string
+	Salt      []byte
+
+	// pbkdf2 iterations or argon2id passes
+	Iterations uint32 `json:",omitempty"`
+
+	// argon2id
+	Memory  uint32 `json:",omitempty"`
+	Threads uint8  `json:",omitempty"`
+
+	// scrypt
+	N int `json:",omitempty"`
+	R int `json:",omitempty"`
+	P int `json:",omitempty"`
+}
+
+func KDFs() []string {
+	return []string{"argon2id", "pbkdf2", "scrypt"}
+}
+
+func NewKDF(algorithm string) (*KDF, error) {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+
+	switch algorithm {
+	case "argon2id":
+		return &KDF{Algorithm: algorithm, Salt: salt, Iterations: 3, Memory: 64 * 1024, Threads: 4}, nil
+	case "scrypt":
+		return &KDF{Algorithm: algorithm, Salt: salt, N: 1 << 15, R: 8, P: 1}, nil
+	case "pbkdf2":
+		return &KDF{Algorithm: algorithm, Salt: salt, Iterations: 600000}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key derivation function: %s", algorithm)
+	}
+}
+
+// legacyKDF is the derivation used by repositories created before the
+// KDF became configurable.
+func legacyKDF(salt []byte) *KDF {
+	return &KDF{Algorithm: "pbkdf2", Salt: salt, Iterations: 4096}
+}
+
+func (kdf *KDF) DeriveKey(passphrase []byte) ([]byte, error) {
+	switch kdf.Algorithm {
+	case "argon2id":
+		return argon2.IDKey(passphrase, kdf.Salt, kdf.Iterations, kdf.Memory, kdf.Threads, 32), nil
+	case "scrypt":
+		return scrypt.Key(passphrase, kdf.Salt, kdf.N, kdf.R, kdf.P, 32)
+	case "pbkdf2":
+		return pbkdf2.Key(passphrase, kdf.Salt, int(kdf.Iterations), 32, sha256.New), nil
+	default:
+		return nil, fmt.Errorf("unsupported key derivation function: %s", kdf.Algorithm)
+	}
+}
+
+// IsLegacy reports whether the KDF parameters are below what is used
+// for new repositories and should be upgraded.
+func (kdf *KDF) IsLegacy() bool {
+	return kdf.Algorithm == "pbkdf2" && kdf.Iterations < 600000
+}
+
+func (kdf *KDF) String() string {
+	switch kdf.Algorithm {
+	case "argon2id":
+		return fmt.Sprintf("argon2id (t=%d, m=%dKiB, p=%d)", kdf.Iterations, kdf.Memory, kdf.Threads)
+	case "scrypt":
+		return fmt.Sprintf("scrypt (N=%d, r=%d, p=%d)", kdf.N, kdf.R, kdf.P)
+	case "pbkdf2":
+		return fmt.Sprintf("pbkdf2-sha256 (i=%d)", kdf.Iterations)
+	default:
+		return kdf.Algorithm
+	}
+}
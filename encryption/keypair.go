@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) 2021 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Keypair identifies the host producing snapshots, the private key signs
+// snapshot metadata and the public key is recorded in it.
+type Keypair struct {
+	PrivateKey ed25519.PrivateKey
+	PublicKey  ed25519.PublicKey
+}
+
+func Keygen() (*Keypair, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Keypair{PrivateKey: privateKey, PublicKey: publicKey}, nil
+}
+
+func (keypair *Keypair) Sign(data []byte) []byte {
+	return ed25519.Sign(keypair.PrivateKey, data)
+}
+
+func (keypair *Keypair) Serialize() []byte {
+	return []byte(base64.StdEncoding.EncodeToString(keypair.PrivateKey) + "\n")
+}
+
+func KeypairFromBytes(data []byte) (*Keypair, error) {
+	privateKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, err
+	}
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key")
+	}
+	return &Keypair{
+		PrivateKey: privateKey,
+		PublicKey:  ed25519.PrivateKey(privateKey).Public().(ed25519.PublicKey),
+	}, nil
+}
+
+func KeypairFromFile(pathname string) (*Keypair, error) {
+	data, err := ioutil.ReadFile(pathname)
+	if err != nil {
+		return nil, err
+	}
+	return KeypairFromBytes(data)
+}
+
+func Verify(publicKey []byte, data []byte, signature []byte) bool {
+	if len(publicKey) != ed25519.PublicKeySize || len(signature) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(publicKey), data, signature)
+}
+
+// TrustedKeysFromFile reads a list of trusted public keys, one base64
+// encoded key per line optionally followed by a comment, blank lines
+// and lines starting with # are ignored.
+func TrustedKeysFromFile(pathname string) ([][]byte, error) {
+	data, err := ioutil.ReadFile(pathname)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([][]byte, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.Fields(line)[0])
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%s:%d: invalid public key", pathname, lineno)
+		}
+		keys = append(keys, key)
+	}
+	return keys, scanner.Err()
+}
@@ -0,0 +1,346 @@
+/*
+ * Copyright (c) 2021 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const secretVersion = 3
+
+// DefaultKeySlot is the name of the slot created along with the repository.
+const DefaultKeySlot = "default"
+
+// RecoveryKeySlot is the name of the slot holding the recovery key.
+const RecoveryKeySlot = "recovery"
+
+// KeySlot is one way of unlocking the repository master key, each slot
+// seals the same master key with a key derived from its own passphrase.
+//
+// Slots converted from repositories created before master keys were
+// introduced have no Key, the derived key is the master key and Digest
+// is used to check it.
+type KeySlot struct {
+	Name    string
+	Created time.Time
+	KDF     *KDF
+	Key     []byte `json:",omitempty"`
+	Digest  []byte `json:",omitempty"`
+}
+
+// wrappedSecret is what gets stored in the repository configuration.
+//
+// Version 1 secrets have a single key wrapped using PBKDF2 with Salt,
+// version 2 secrets have a single key wrapped using KDF, version 3
+// secrets have key slots and may publish the repository public key
+// used in write-only mode.
+type wrappedSecret struct {
+	Version   int
+	Salt      []byte    `json:",omitempty"`
+	KDF       *KDF      `json:",omitempty"`
+	Key       []byte    `json:",omitempty"`
+	Slots     []KeySlot `json:",omitempty"`
+	PublicKey []byte    `json:",omitempty"`
+}
+
+func sealKey(kek []byte, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aesGCM.NonceSize())
+	rand.Read(nonce)
+	return aesGCM.Seal(nonce, nonce, key, nil), nil
+}
+
+func openKey(kek []byte, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aesGCM.NonceSize() {
+		return nil, fmt.Errorf("invalid wrapped key")
+	}
+	nonce, ciphertext := sealed[:aesGCM.NonceSize()], sealed[aesGCM.NonceSize():]
+	return aesGCM.Open(nil, nonce, ciphertext, nil)
+}
+
+func newKeySlot(name string, passphrase []byte, masterKey []byte, algorithm string) (*KeySlot, error) {
+	kdf, err := NewKDF(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	kek, err := kdf.DeriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := sealKey(kek, masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeySlot{
+		Name:    name,
+		Created: time.Now(),
+		KDF:     kdf,
+		Key:     key,
+	}, nil
+}
+
+func (slot *KeySlot) unlock(passphrase []byte) ([]byte, error) {
+	kek, err := slot.KDF.DeriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if slot.Key == nil {
+		sum := sha256.Sum256(kek)
+		if !bytes.Equal(sum[:], slot.Digest) {
+			return nil, fmt.Errorf("passphrase does not match")
+		}
+		return kek, nil
+	}
+
+	masterKey, err := openKey(kek, slot.Key)
+	if err != nil {
+		return nil, fmt.Errorf("passphrase does not match")
+	}
+	return masterKey, nil
+}
+
+// parseSecret returns the key slots and public key of a secret,
+// whatever its version.
+func parseSecret(secret string) ([]KeySlot, []byte, error) {
+	decoded_secret, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var wrapped wrappedSecret
+	if err := json.Unmarshal(decoded_secret, &wrapped); err != nil {
+		if len(decoded_secret) != 16+sha256.Size {
+			return nil, nil, fmt.Errorf("invalid secret")
+		}
+		return []KeySlot{{
+			Name:   DefaultKeySlot,
+			KDF:    legacyKDF(decoded_secret[0:16]),
+			Digest: decoded_secret[16:],
+		}}, nil, nil
+	}
+
+	switch wrapped.Version {
+	case 1:
+		return []KeySlot{{Name: DefaultKeySlot, KDF: legacyKDF(wrapped.Salt), Key: wrapped.Key}}, nil, nil
+	case 2:
+		if wrapped.KDF == nil {
+			return nil, nil, fmt.Errorf("missing key derivation parameters")
+		}
+		return []KeySlot{{Name: DefaultKeySlot, KDF: wrapped.KDF, Key: wrapped.Key}}, nil, nil
+	case 3:
+		for _, slot := range wrapped.Slots {
+			if slot.KDF == nil {
+				return nil, nil, fmt.Errorf("missing key derivation parameters")
+			}
+		}
+		return wrapped.Slots, wrapped.PublicKey, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported secret version: %d", wrapped.Version)
+	}
+}
+
+func serializeSecret(slots []KeySlot, publicKey []byte) (string, error) {
+	serialized, err := json.Marshal(&wrappedSecret{
+		Version:   secretVersion,
+		Slots:     slots,
+		PublicKey: publicKey,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(serialized), nil
+}
+
+// BuildSecretFromPassphrase generates a random master key for a new
+// repository and wraps it with the passphrase in the default slot.
+func BuildSecretFromPassphrase(passphrase []byte, algorithm string) (string, []byte, error) {
+	masterKey := make([]byte, 32)
+	rand.Read(masterKey)
+
+	slot, err := newKeySlot(DefaultKeySlot, passphrase, masterKey, algorithm)
+	if err != nil {
+		return "", nil, err
+	}
+
+	publicKey, err := PublicKey(masterKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	secret, err := serializeSecret([]KeySlot{*slot}, publicKey)
+	if err != nil {
+		return "", nil, err
+	}
+	return secret, masterKey, nil
+}
+
+// UnlockSecret returns the repository master key and the slot that
+// the passphrase unlocked.
+func UnlockSecret(passphrase []byte, secret string) ([]byte, *KeySlot, error) {
+	slots, _, err := parseSecret(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range slots {
+		masterKey, err := slots[i].unlock(passphrase)
+		if err == nil {
+			return masterKey, &slots[i], nil
+		}
+	}
+	return nil, nil, fmt.Errorf("passphrase does not match")
+}
+
+// DeriveSecret returns the repository master key.
+func DeriveSecret(passphrase []byte, secret string) ([]byte, error) {
+	masterKey, _, err := UnlockSecret(passphrase, secret)
+	return masterKey, err
+}
+
+// KeySlots returns the key slots of a secret.
+func KeySlots(secret string) ([]KeySlot, error) {
+	slots, _, err := parseSecret(secret)
+	return slots, err
+}
+
+// SecretPublicKey returns the repository public key used in write-only
+// mode, older repositories publish it once their key slots are updated.
+func SecretPublicKey(secret string) ([]byte, error) {
+	_, publicKey, err := parseSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	if publicKey == nil {
+		return nil, fmt.Errorf("repository has no public key, run `plakar passwd` to publish it")
+	}
+	return publicKey, nil
+}
+
+// AddKeySlot wraps the master key with a new passphrase in a new slot.
+func AddKeySlot(secret string, masterKey []byte, name string, passphrase []byte, algorithm string) (string, error) {
+	slots, _, err := parseSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	for _, slot := range slots {
+		if slot.Name == name {
+			return "", fmt.Errorf("key slot already exists: %s", name)
+		}
+	}
+
+	slot, err := newKeySlot(name, passphrase, masterKey, algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	publicKey, err := PublicKey(masterKey)
+	if err != nil {
+		return "", err
+	}
+	return serializeSecret(append(slots, *slot), publicKey)
+}
+
+// ReplaceKeySlot wraps the master key with a new passphrase in place of
+// an existing slot.
+func ReplaceKeySlot(secret string, masterKey []byte, name string, passphrase []byte, algorithm string) (string, error) {
+	slots, _, err := parseSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	publicKey, err := PublicKey(masterKey)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range slots {
+		if slots[i].Name == name {
+			slot, err := newKeySlot(name, passphrase, masterKey, algorithm)
+			if err != nil {
+				return "", err
+			}
+			slots[i] = *slot
+			return serializeSecret(slots, publicKey)
+		}
+	}
+	return "", fmt.Errorf("no such key slot: %s", name)
+}
+
+// RemoveKeySlot removes a slot, the last slot can't be removed as the
+// master key would no longer be recoverable. The master key is left as
+// is, removing a slot doesn't revoke access from someone who already
+// unwrapped it or kept a copy of the secret.
+func RemoveKeySlot(secret string, name string) (string, error) {
+	slots, publicKey, err := parseSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range slots {
+		if slots[i].Name == name {
+			if len(slots) == 1 {
+				return "", fmt.Errorf("can't remove the last key slot")
+			}
+			return serializeSecret(append(slots[:i], slots[i+1:]...), publicKey)
+		}
+	}
+	return "", fmt.Errorf("no such key slot: %s", name)
+}
+
+// NewRecoveryKey generates a random printable key, meant to be written
+// down and stored offline.
+func NewRecoveryKey() string {
+	buf := make([]byte, 20)
+	rand.Read(buf)
+	encoded := base32.StdEncoding.EncodeToString(buf)
+
+	groups := make([]string, 0)
+	for i := 0; i < len(encoded); i += 4 {
+		groups = append(groups, encoded[i:i+4])
+	}
+	return strings.Join(groups, "-")
+}
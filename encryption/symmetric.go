@@ -21,80 +21,169 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
-	"crypto/sha256"
-	"encoding/base64"
 	"fmt"
+)
+
+// Blobs are sealed in a versioned envelope:
+//
+//	magic | version | algorithm | wrapped subkey | nonce | ciphertext
+//
+// where the random subkey is wrapped with AES-GCM under the master key
+// and the payload is sealed with AES-GCM under the subkey. The header
+// and the caller-provided associated data are authenticated, so a blob
+// can't be moved to another checksum or passed off as another type.
+const (
+	envelopeVersion = 1
 
-	"golang.org/x/crypto/pbkdf2"
+	algorithmAES256GCM       = 1
+	algorithmX25519AES256GCM = 2
 )
 
-func BuildSecretFromPassphrase(passphrase []byte) string {
-	salt := make([]byte, 16)
-	rand.Read(salt)
-	dk := pbkdf2.Key(passphrase, salt, 4096, 32, sha256.New)
-	secret := sha256.Sum256(dk)
-	return base64.StdEncoding.EncodeToString(append(salt, secret[:]...))
+var envelopeMagic = []byte("plk")
+
+const envelopeHeaderSize = 5
+
+func envelopeHeader(algorithm byte) []byte {
+	return []byte{envelopeMagic[0], envelopeMagic[1], envelopeMagic[2], envelopeVersion, algorithm}
+}
+
+// AssociatedData binds an encrypted blob to its type and key.
+func AssociatedData(blobType string, key string) []byte {
+	return []byte(blobType + "\x00" + key)
 }
 
-func DeriveSecret(passphrase []byte, secret string) ([]byte, error) {
-	decoded_secret, err := base64.StdEncoding.DecodeString(secret)
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
+	return cipher.NewGCM(block)
+}
 
-	salt, sum := decoded_secret[0:16], decoded_secret[16:]
-	dk := pbkdf2.Key(passphrase, salt, 4096, 32, sha256.New)
-	dksum := sha256.Sum256(dk)
-	if !bytes.Equal(dksum[:], sum) {
-		return nil, fmt.Errorf("passphrase does not match")
+// wrapSubkey appends the nonce and the subkey sealed under key to envelope.
+func wrapSubkey(envelope []byte, key []byte, subkey []byte, header []byte) ([]byte, error) {
+	wrapper, err := newGCM(key)
+	if err != nil {
+		return nil, err
 	}
-	return dk, nil
+	nonce := make([]byte, wrapper.NonceSize())
+	rand.Read(nonce)
+	return wrapper.Seal(append(envelope, nonce...), nonce, subkey, header), nil
 }
 
-func Encrypt(key []byte, buf []byte) ([]byte, error) {
-	subkey := make([]byte, 32)
-	rand.Read(subkey)
+// unwrapSubkey returns the subkey sealed under key and the rest of buf.
+func unwrapSubkey(key []byte, buf []byte, header []byte) ([]byte, []byte, error) {
+	wrapper, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	wrappedSize := wrapper.NonceSize() + 32 + wrapper.Overhead()
+	if len(buf) < wrappedSize {
+		return nil, nil, fmt.Errorf("invalid encrypted blob")
+	}
+	nonce, wrapped := buf[:wrapper.NonceSize()], buf[wrapper.NonceSize():wrappedSize]
+	subkey, err := wrapper.Open(nil, nonce, wrapped, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	return subkey, buf[wrappedSize:], nil
+}
 
-	ecb, err := aes.NewCipher(key)
+func sealPayload(envelope []byte, subkey []byte, buf []byte, header []byte, associatedData []byte) ([]byte, error) {
+	aesGCM, err := newGCM(subkey)
 	if err != nil {
 		return nil, err
 	}
-	encsubkey := make([]byte, ecb.BlockSize()*2)
-	ecb.Encrypt(encsubkey[:ecb.BlockSize()], subkey[:ecb.BlockSize()])
-	ecb.Encrypt(encsubkey[ecb.BlockSize():], subkey[ecb.BlockSize():])
+	nonce := make([]byte, aesGCM.NonceSize())
+	rand.Read(nonce)
+	envelope = append(envelope, nonce...)
+	return aesGCM.Seal(envelope, nonce, buf, append(append([]byte{}, header...), associatedData...)), nil
+}
 
-	block, err := aes.NewCipher(subkey)
+func openPayload(subkey []byte, buf []byte, header []byte, associatedData []byte) ([]byte, error) {
+	aesGCM, err := newGCM(subkey)
 	if err != nil {
 		return nil, err
 	}
-	aesGCM, err := cipher.NewGCM(block)
+	if len(buf) < aesGCM.NonceSize() {
+		return nil, fmt.Errorf("invalid encrypted blob")
+	}
+	nonce, ciphertext := buf[:aesGCM.NonceSize()], buf[aesGCM.NonceSize():]
+	return aesGCM.Open(nil, nonce, ciphertext, append(append([]byte{}, header...), associatedData...))
+}
+
+func Encrypt(key []byte, buf []byte, associatedData []byte) ([]byte, error) {
+	subkey := make([]byte, 32)
+	rand.Read(subkey)
+
+	header := envelopeHeader(algorithmAES256GCM)
+	envelope, err := wrapSubkey(header, key, subkey, header)
 	if err != nil {
 		return nil, err
 	}
-	nonce := make([]byte, aesGCM.NonceSize())
-	rand.Read(nonce)
+	return sealPayload(envelope, subkey, buf, header, associatedData)
+}
 
-	return append(encsubkey[:], aesGCM.Seal(nonce, nonce, buf, nil)[:]...), nil
+func Decrypt(key []byte, buf []byte, associatedData []byte) ([]byte, error) {
+	// the format is decided from the header alone, falling back to the
+	// legacy format after a failure would let a blob stripped of its
+	// associated data binding be passed off as any other
+	if !isEnvelope(buf) {
+		return decryptLegacy(key, buf)
+	}
+	return decryptEnvelope(key, buf, associatedData)
 }
 
-func Decrypt(key []byte, buf []byte) ([]byte, error) {
+// isEnvelope reports whether buf starts with an envelope header, legacy
+// blobs start with a wrapped subkey and are unlikely to match all of it.
+func isEnvelope(buf []byte) bool {
+	if len(buf) < envelopeHeaderSize || !bytes.Equal(buf[:len(envelopeMagic)], envelopeMagic) {
+		return false
+	}
+	if buf[3] != envelopeVersion {
+		return false
+	}
+	return buf[4] == algorithmAES256GCM || buf[4] == algorithmX25519AES256GCM
+}
+
+func decryptEnvelope(key []byte, buf []byte, associatedData []byte) ([]byte, error) {
+	header, buf := buf[:envelopeHeaderSize], buf[envelopeHeaderSize:]
+
+	var subkey []byte
+	var err error
+	if header[4] == algorithmX25519AES256GCM {
+		subkey, buf, err = unwrapSealedSubkey(key, buf, header)
+	} else {
+		subkey, buf, err = unwrapSubkey(key, buf, header)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return openPayload(subkey, buf, header, associatedData)
+}
+
+// decryptLegacy handles blobs written before the envelope format, the
+// subkey was wrapped with raw AES and no associated data was bound.
+func decryptLegacy(key []byte, buf []byte) ([]byte, error) {
 	ecb, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
+	if len(buf) < ecb.BlockSize()*2 {
+		return nil, fmt.Errorf("invalid encrypted blob")
+	}
 	subkey := make([]byte, ecb.BlockSize()*2)
 
 	encsubkey, ciphertext := buf[:ecb.BlockSize()*2], buf[ecb.BlockSize()*2:]
 	ecb.Decrypt(subkey[ecb.BlockSize():], encsubkey[ecb.BlockSize():])
 	ecb.Decrypt(subkey[:ecb.BlockSize()], encsubkey[:ecb.BlockSize()])
 
-	block, err := aes.NewCipher(subkey)
+	aesGCM, err := newGCM(subkey)
 	if err != nil {
 		return nil, err
 	}
-	aesGCM, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
+	if len(ciphertext) < aesGCM.NonceSize() {
+		return nil, fmt.Errorf("invalid encrypted blob")
 	}
 	nonce, ciphertext := ciphertext[:aesGCM.NonceSize()], ciphertext[aesGCM.NonceSize():]
 	cleartext, err := aesGCM.Open(nil, nonce, ciphertext, nil)
@@ -0,0 +1,220 @@
+package filesystem
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/poolpOrg/plakar/logger"
+)
+
+// IgnoreFile holds exclude patterns for the directory it is in and its
+// subdirectories, with the same syntax as .gitignore files.
+const IgnoreFile = ".plakarignore"
+
+type excludePattern struct {
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// Excludes decides which pathnames are excluded from a scan, patterns
+// are matched relative to the scanned directory and patterns read from
+// ignore files relative to the directory holding them. The last
+// matching pattern wins and patterns from deeper ignore files take
+// precedence.
+type Excludes struct {
+	root     string
+	patterns []excludePattern
+
+	muIgnoreFiles sync.Mutex
+	ignoreFiles   map[string][]excludePattern
+}
+
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				if i+2 < len(glob) && glob[i+2] == '/' {
+					// "**/" matches zero or more directories
+					b.WriteString("(.*/)?")
+					i += 2
+				} else {
+					b.WriteString(".*")
+					i++
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == -1 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				b.WriteString(regexp.QuoteMeta(string(glob[i+1])))
+				i++
+			} else {
+				b.WriteString(`\\`)
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// parseExcludePattern returns nil for blank lines and comments.
+func parseExcludePattern(line string) (*excludePattern, error) {
+	line = strings.TrimRight(line, " \t\r")
+	if strings.HasSuffix(line, `\`) {
+		line += " "
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+
+	pattern := &excludePattern{}
+	if strings.HasPrefix(line, "!") {
+		pattern.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		pattern.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil, nil
+	}
+
+	// a slash anywhere but at the end anchors the pattern, otherwise it
+	// matches a name at any depth
+	var expr string
+	if strings.Contains(line, "/") {
+		expr = "^" + globToRegexp(strings.TrimPrefix(line, "/")) + "$"
+	} else {
+		expr = "^(.*/)?" + globToRegexp(line) + "$"
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exclude pattern: %s", line)
+	}
+	pattern.re = re
+	return pattern, nil
+}
+
+func parseExcludePatterns(lines []string) ([]excludePattern, error) {
+	patterns := make([]excludePattern, 0)
+	for _, line := range lines {
+		pattern, err := parseExcludePattern(line)
+		if err != nil {
+			return nil, err
+		}
+		if pattern != nil {
+			patterns = append(patterns, *pattern)
+		}
+	}
+	return patterns, nil
+}
+
+// ReadExcludeFile returns the lines of a file holding exclude patterns.
+func ReadExcludeFile(pathname string) ([]string, error) {
+	fp, err := os.Open(pathname)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+func NewExcludes(root string, patterns []string) (*Excludes, error) {
+	parsed, err := parseExcludePatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return &Excludes{
+		root:        filepath.Clean(root),
+		patterns:    parsed,
+		ignoreFiles: make(map[string][]excludePattern),
+	}, nil
+}
+
+func (excludes *Excludes) loadIgnoreFile(directory string) []excludePattern {
+	excludes.muIgnoreFiles.Lock()
+	defer excludes.muIgnoreFiles.Unlock()
+
+	if patterns, exists := excludes.ignoreFiles[directory]; exists {
+		return patterns
+	}
+
+	var patterns []excludePattern
+	lines, err := ReadExcludeFile(filepath.Join(directory, IgnoreFile))
+	if err == nil {
+		patterns, err = parseExcludePatterns(lines)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		logger.Warn("%s: %s", filepath.Join(directory, IgnoreFile), err)
+	}
+	excludes.ignoreFiles[directory] = patterns
+	return patterns
+}
+
+func matchPatterns(patterns []excludePattern, relpath string, isDir bool, excluded bool) bool {
+	for _, pattern := range patterns {
+		if pattern.dirOnly && !isDir {
+			continue
+		}
+		if pattern.re.MatchString(relpath) {
+			excluded = !pattern.negate
+		}
+	}
+	return excluded
+}
+
+// Excluded reports whether pathname, found under the scanned directory,
+// is excluded. Children of an excluded directory are never visited.
+func (excludes *Excludes) Excluded(pathname string, isDir bool) bool {
+	pathname = filepath.Clean(pathname)
+
+	relpath, err := filepath.Rel(excludes.root, pathname)
+	if err != nil || relpath == "." || relpath == ".." || strings.HasPrefix(relpath, "../") {
+		return false
+	}
+
+	excluded := matchPatterns(excludes.patterns, relpath, isDir, false)
+
+	directory := excludes.root
+	atoms := strings.Split(relpath, "/")
+	for i := 0; i < len(atoms); i++ {
+		patterns := excludes.loadIgnoreFile(directory)
+		if len(patterns) != 0 {
+			excluded = matchPatterns(patterns, strings.Join(atoms[i:], "/"), isDir, excluded)
+		}
+		directory = filepath.Join(directory, atoms[i])
+	}
+	return excluded
+}
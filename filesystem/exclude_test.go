@@ -0,0 +1,91 @@
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExcludePatterns(t *testing.T) {
+	excludes, err := NewExcludes("/root", []string{
+		"# comment",
+		"",
+		"*.o",
+		"!keep.o",
+		"node_modules/",
+		"/build",
+		"docs/**/*.tmp",
+		"cache/**",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		pathname string
+		isDir    bool
+		excluded bool
+	}{
+		{"/root/main.o", false, true},
+		{"/root/src/main.o", false, true},
+		{"/root/src/keep.o", false, false},
+		{"/root/main.c", false, false},
+		{"/root/node_modules", true, true},
+		{"/root/web/node_modules", true, true},
+		{"/root/node_modules", false, false},
+		{"/root/build", true, true},
+		{"/root/src/build", true, false},
+		{"/root/docs/a.tmp", false, true},
+		{"/root/docs/a/b/c.tmp", false, true},
+		{"/root/a.tmp", false, false},
+		{"/root/cache/x", false, true},
+		{"/root/cache", true, false},
+		{"/root", true, false},
+		{"/elsewhere/main.o", false, false},
+	}
+	for _, test := range tests {
+		if excludes.Excluded(test.pathname, test.isDir) != test.excluded {
+			t.Errorf("Excluded(%s, %v) != %v", test.pathname, test.isDir, test.excluded)
+		}
+	}
+}
+
+func TestIgnoreFile(t *testing.T) {
+	root, err := ioutil.TempDir("", "plakar-exclude-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	os.MkdirAll(filepath.Join(root, "sub"), 0700)
+	ioutil.WriteFile(filepath.Join(root, IgnoreFile), []byte("*.log\n/top\n"), 0600)
+	ioutil.WriteFile(filepath.Join(root, "sub", IgnoreFile), []byte("!important.log\ntop\n"), 0600)
+
+	excludes, err := NewExcludes(root, []string{"*.bak"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		pathname string
+		excluded bool
+	}{
+		{"debug.log", true},
+		{"sub/debug.log", true},
+		{"sub/important.log", false},
+		{"top", true},
+		{"sub/top", true},
+		{"sub/file.bak", true},
+		{"sub/file.txt", false},
+	}
+	for _, test := range tests {
+		if excludes.Excluded(filepath.Join(root, test.pathname), false) != test.excluded {
+			t.Errorf("Excluded(%s) != %v", test.pathname, test.excluded)
+		}
+	}
+
+	if _, err := NewExcludes(root, []string{"[z-a]"}); err == nil {
+		t.Errorf("NewExcludes() should fail with an invalid pattern")
+	}
+}
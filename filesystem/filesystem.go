@@ -1,11 +1,15 @@
 package filesystem
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -23,6 +27,13 @@ type Fileinfo struct {
 	Ino     uint64
 	Uid     uint64
 	Gid     uint64
+	Rdev    uint64 `json:",omitempty"`
+
+	Username  string `json:",omitempty"`
+	Groupname string `json:",omitempty"`
+
+	Xattrs map[string][]byte `json:",omitempty"`
+	ACLs   map[string][]byte `json:",omitempty"`
 }
 
 type FilesystemNode struct {
@@ -62,18 +73,63 @@ type Filesystem struct {
 
 	muSymlinks sync.Mutex
 	Symlinks   map[string]string
+
+	excluded uint64
+
+	muSkipped sync.Mutex
+	skipped   []SkippedPathname
+}
+
+// SkippedPathname is a pathname left out of a scan by a policy.
+type SkippedPathname struct {
+	Pathname string
+	Reason   string
+}
+
+type ScanOptions struct {
+	// pathnames starting with one of these prefixes are skipped
+	SkipDirs []string
+
+	// gitignore-style patterns, relative to the scanned directory
+	Excludes []string
+
+	// do not descend into directories on another device than the
+	// scanned directory, mount points themselves are recorded
+	OneFileSystem bool
+
+	// skip entries whose type matches, e.g. os.ModeSocket
+	SkipModes os.FileMode
+
+	// skip regular files larger than this size, 0 for no limit
+	MaxSize int64
+
+	// record extended attributes and POSIX ACLs
+	Xattrs bool
+	ACLs   bool
+
+	// stops the scan when done, what was scanned so far is kept
+	Context context.Context
 }
 
+// errSkipEntry is returned to the walker so that it does not descend
+// into excluded or skipped directories, it is not reported as an error.
+var errSkipEntry = errors.New("skipped")
+
 func FileinfoFromStat(stat os.FileInfo) Fileinfo {
+	uid := uint64(stat.Sys().(*syscall.Stat_t).Uid)
+	gid := uint64(stat.Sys().(*syscall.Stat_t).Gid)
 	return Fileinfo{
-		Name:    stat.Name(),
-		Size:    stat.Size(),
-		Mode:    stat.Mode(),
-		ModTime: stat.ModTime(),
-		Dev:     uint64(stat.Sys().(*syscall.Stat_t).Dev),
-		Ino:     uint64(stat.Sys().(*syscall.Stat_t).Ino),
-		Uid:     uint64(stat.Sys().(*syscall.Stat_t).Uid),
-		Gid:     uint64(stat.Sys().(*syscall.Stat_t).Gid),
+		Name:      stat.Name(),
+		Size:      stat.Size(),
+		Mode:      stat.Mode(),
+		ModTime:   stat.ModTime(),
+		Dev:       uint64(stat.Sys().(*syscall.Stat_t).Dev),
+		Ino:       uint64(stat.Sys().(*syscall.Stat_t).Ino),
+		Uid:       uid,
+		Gid:       gid,
+		Rdev:      uint64(stat.Sys().(*syscall.Stat_t).Rdev),
+		Username:  lookupUsername(uid),
+		Groupname: lookupGroupname(gid),
 	}
 }
 
@@ -150,7 +206,7 @@ func (filesystem *Filesystem) buildTree(pathname string, fileinfo *Fileinfo) {
 	}
 }
 
-func (filesystem *Filesystem) Scan(directory string, skip []string) error {
+func (filesystem *Filesystem) Scan(directory string, options *ScanOptions) error {
 	directory = filepath.Clean(directory)
 	for _, scanned := range filesystem.ScannedDirectories {
 		if scanned == directory {
@@ -161,6 +217,11 @@ func (filesystem *Filesystem) Scan(directory string, skip []string) error {
 	filesystem.ScannedDirectories = append(filesystem.ScannedDirectories, directory)
 	filesystem.muScannedDirectories.Unlock()
 
+	excludes, err := NewExcludes(directory, options.Excludes)
+	if err != nil {
+		return err
+	}
+
 	atoms := strings.Split(directory, "/")
 	for i := len(atoms) - 1; i != 0; i-- {
 		path := filepath.Clean(fmt.Sprintf("/%s", strings.Join(atoms[0:i], "/")))
@@ -172,13 +233,23 @@ func (filesystem *Filesystem) Scan(directory string, skip []string) error {
 		filesystem.buildTree(path, &fi)
 	}
 
-	err := cwalk.Walk(directory, func(path string, f os.FileInfo, err error) error {
+	root, err := os.Stat(directory)
+	if err != nil {
+		return err
+	}
+	rootDev := FileinfoFromStat(root).Dev
+
+	err = cwalk.Walk(directory, func(path string, f os.FileInfo, err error) error {
 		if err != nil {
 			logger.Warn("%s", err)
 			return nil
 		}
 
-		for _, skipPath := range skip {
+		if options.Context != nil && options.Context.Err() != nil {
+			return errSkipEntry
+		}
+
+		for _, skipPath := range options.SkipDirs {
 			if strings.HasPrefix(fmt.Sprintf("%s/%s", directory, path), skipPath) {
 				return nil
 			}
@@ -186,9 +257,34 @@ func (filesystem *Filesystem) Scan(directory string, skip []string) error {
 
 		pathname := fmt.Sprintf("%s/%s", directory, path)
 
+		if excludes.Excluded(pathname, f.IsDir()) {
+			atomic.AddUint64(&filesystem.excluded, 1)
+			return errSkipEntry
+		}
+
+		if f.Mode()&options.SkipModes != 0 {
+			filesystem.skip(pathname, fileTypeName(f.Mode()))
+			return nil
+		}
+
+		if options.MaxSize != 0 && f.Mode().IsRegular() && f.Size() > options.MaxSize {
+			filesystem.skip(pathname, fmt.Sprintf("too large (%s)", humanize.Bytes(uint64(f.Size()))))
+			return nil
+		}
+
 		fileinfo := FileinfoFromStat(f)
+		if options.Xattrs || options.ACLs {
+			if err := readExtendedAttributes(pathname, &fileinfo, options.Xattrs, options.ACLs); err != nil {
+				logger.Warn("%s: %s", pathname, err)
+			}
+		}
 		filesystem.buildTree(pathname, &fileinfo)
 
+		if options.OneFileSystem && fileinfo.Mode.IsDir() && fileinfo.Dev != rootDev {
+			filesystem.skip(pathname, "other filesystem")
+			return errSkipEntry
+		}
+
 		if !fileinfo.Mode.IsDir() && !fileinfo.Mode.IsRegular() {
 			lstat, err := os.Lstat(pathname)
 			if err != nil {
@@ -198,12 +294,14 @@ func (filesystem *Filesystem) Scan(directory string, skip []string) error {
 
 			lfileinfo := FileinfoFromStat(lstat)
 			if lfileinfo.Mode&os.ModeSymlink != 0 {
-				originFile, err := os.Readlink(lfileinfo.Name)
+				originFile, err := os.Readlink(pathname)
 				if err != nil {
 					logger.Warn("%s", err)
 					return nil
 				}
 
+				pathname = filepath.Clean(pathname)
+
 				filesystem.muLstat.Lock()
 				filesystem.Lstat = append(filesystem.Lstat, pathname)
 				filesystem.lstatInfo[pathname] = &lfileinfo
@@ -217,12 +315,65 @@ func (filesystem *Filesystem) Scan(directory string, skip []string) error {
 
 		return nil
 	})
+	if errList, ok := err.(cwalk.WalkerErrorList); ok {
+		remaining := make([]cwalk.WalkerError, 0)
+		for _, walkerError := range errList.ErrorList {
+			if walkerError.Error() != errSkipEntry.Error() {
+				remaining = append(remaining, walkerError)
+			}
+		}
+		if len(remaining) == 0 {
+			err = nil
+		} else {
+			err = cwalk.WalkerErrorList{ErrorList: remaining}
+		}
+	}
 	if err != nil {
 		logger.Warn("%s", err)
 	}
+	if err == nil && options.Context != nil {
+		err = options.Context.Err()
+	}
 	return err
 }
 
+func fileTypeName(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&os.ModeDevice != 0:
+		return "device"
+	default:
+		return mode.Type().String()
+	}
+}
+
+func (filesystem *Filesystem) skip(pathname string, reason string) {
+	logger.Info("%s: skipped: %s", pathname, reason)
+	filesystem.muSkipped.Lock()
+	filesystem.skipped = append(filesystem.skipped, SkippedPathname{Pathname: filepath.Clean(pathname), Reason: reason})
+	filesystem.muSkipped.Unlock()
+}
+
+func (filesystem *Filesystem) ListSkipped() []SkippedPathname {
+	list := make([]SkippedPathname, 0)
+	filesystem.muSkipped.Lock()
+	list = append(list, filesystem.skipped...)
+	filesystem.muSkipped.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Pathname < list[j].Pathname
+	})
+	return list
+}
+
+// ExcludedCount returns the number of pathnames excluded while scanning,
+// the content of excluded directories is not accounted.
+func (filesystem *Filesystem) ExcludedCount() uint64 {
+	return atomic.LoadUint64(&filesystem.excluded)
+}
+
 func (filesystem *Filesystem) Lookup(pathname string) (*FilesystemNode, error) {
 	pathname = filepath.Clean(pathname)
 
@@ -269,6 +420,14 @@ func (filesystem *Filesystem) LookupInodeForDirectory(pathname string) (*Fileinf
 	return fileinfo, exists
 }
 
+// LookupSymlink returns the target of a symbolic link.
+func (filesystem *Filesystem) LookupSymlink(pathname string) (string, bool) {
+	filesystem.muSymlinks.Lock()
+	target, exists := filesystem.Symlinks[filepath.Clean(pathname)]
+	filesystem.muSymlinks.Unlock()
+	return target, exists
+}
+
 func (filesystem *Filesystem) ListFiles() []string {
 	list := make([]string, 0)
 	filesystem.muFiles.Lock()
@@ -0,0 +1,245 @@
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestScanSkip(t *testing.T) {
+	root, err := ioutil.TempDir("", "plakar-scan-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	os.MkdirAll(filepath.Join(root, "sub"), 0700)
+	ioutil.WriteFile(filepath.Join(root, "small"), []byte("small"), 0600)
+	ioutil.WriteFile(filepath.Join(root, "sub", "large"), make([]byte, 4096), 0600)
+	if err := syscall.Mkfifo(filepath.Join(root, "sub", "fifo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := NewFilesystem()
+	err = fs.Scan(root, &ScanOptions{
+		OneFileSystem: true,
+		SkipModes:     os.ModeNamedPipe,
+		MaxSize:       1024,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, exists := fs.LookupInodeForFile(filepath.Join(root, "small")); !exists {
+		t.Errorf("small file should have been scanned")
+	}
+
+	expected := []SkippedPathname{
+		{filepath.Join(root, "sub", "fifo"), "fifo"},
+		{filepath.Join(root, "sub", "large"), "too large (4.1 kB)"},
+	}
+	skipped := fs.ListSkipped()
+	if len(skipped) != len(expected) {
+		t.Fatalf("ListSkipped() = %v, expected %v", skipped, expected)
+	}
+	for i := range expected {
+		if skipped[i] != expected[i] {
+			t.Errorf("ListSkipped()[%d] = %v, expected %v", i, skipped[i], expected[i])
+		}
+	}
+}
+
+func TestExtendedAttributes(t *testing.T) {
+	root, err := ioutil.TempDir("", "plakar-xattr-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	src := filepath.Join(root, "src")
+	dst := filepath.Join(root, "dst")
+	ioutil.WriteFile(src, []byte("src"), 0600)
+	ioutil.WriteFile(dst, []byte("dst"), 0600)
+	if err := setXattr(src, "user.tag", []byte("blue")); err != nil {
+		t.Skipf("extended attributes not supported: %s", err)
+	}
+
+	fileinfo := Fileinfo{}
+	if err := readExtendedAttributes(src, &fileinfo, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(fileinfo.Xattrs) != 0 {
+		t.Errorf("xattrs should not have been recorded")
+	}
+
+	if err := readExtendedAttributes(src, &fileinfo, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if string(fileinfo.Xattrs["user.tag"]) != "blue" {
+		t.Fatalf("Xattrs = %v", fileinfo.Xattrs)
+	}
+
+	if err := fileinfo.RestoreXattrs(dst); err != nil {
+		t.Fatal(err)
+	}
+	restored := Fileinfo{}
+	readExtendedAttributes(dst, &restored, true, false)
+	if !equalAttributes(fileinfo.Xattrs, restored.Xattrs) {
+		t.Errorf("restored xattrs %v != %v", restored.Xattrs, fileinfo.Xattrs)
+	}
+}
+
+func TestFormatACL(t *testing.T) {
+	acl := []byte{
+		0x02, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x06, 0x00, 0xff, 0xff, 0xff, 0xff,
+		0x02, 0x00, 0x05, 0x00, 0xe8, 0x03, 0x00, 0x00,
+		0x04, 0x00, 0x04, 0x00, 0xff, 0xff, 0xff, 0xff,
+		0x10, 0x00, 0x05, 0x00, 0xff, 0xff, 0xff, 0xff,
+		0x20, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff,
+	}
+	expected := "user::rw-,user:1000:r-x,group::r--,mask::r-x,other::---"
+	if FormatACL(acl) != expected {
+		t.Errorf("FormatACL() = %s, expected %s", FormatACL(acl), expected)
+	}
+
+	if FormatXattr([]byte{0x01, 0x00, 0x00, 0x02}) != "0x01000002" {
+		t.Errorf("FormatXattr() = %s", FormatXattr([]byte{0x01, 0x00, 0x00, 0x02}))
+	}
+}
+
+func TestScanSpecialFiles(t *testing.T) {
+	root, err := ioutil.TempDir("", "plakar-scan-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	os.Symlink("target", filepath.Join(root, "link"))
+	if err := syscall.Mkfifo(filepath.Join(root, "fifo"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := NewFilesystem()
+	if err := fs.Scan(root, &ScanOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if target, exists := fs.LookupSymlink(filepath.Join(root, "link")); !exists || target != "target" {
+		t.Errorf("LookupSymlink() = %s, %v", target, exists)
+	}
+
+	fileinfo, exists := fs.LookupInode(filepath.Join(root, "fifo"))
+	if !exists || fileinfo.Mode&os.ModeNamedPipe == 0 {
+		t.Fatalf("fifo was not scanned")
+	}
+	restored := filepath.Join(root, "restored")
+	if err := fileinfo.Mknod(restored); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := os.Lstat(restored); err != nil || st.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("Mknod() did not create a fifo")
+	}
+}
+
+func TestOwner(t *testing.T) {
+	fileinfo := Fileinfo{Uid: 12345, Gid: 12345, Username: "root", Groupname: "plakar-unknown-group"}
+
+	if uid, gid := fileinfo.Owner(true); uid != 12345 || gid != 12345 {
+		t.Errorf("Owner(true) = %d, %d", uid, gid)
+	}
+	if uid, gid := fileinfo.Owner(false); uid != 0 || gid != 12345 {
+		t.Errorf("Owner(false) = %d, %d", uid, gid)
+	}
+}
+
+func TestRestoreTimes(t *testing.T) {
+	root, err := ioutil.TempDir("", "plakar-times-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	pathname := filepath.Join(root, "link")
+	os.Symlink("nonexistent", pathname)
+
+	fileinfo := Fileinfo{ModTime: time.Date(2001, 2, 3, 4, 5, 6, 7, time.UTC)}
+	if err := fileinfo.RestoreTimes(pathname); err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Lstat(pathname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.ModTime().Equal(fileinfo.ModTime) {
+		t.Errorf("modification time = %s, expected %s", st.ModTime(), fileinfo.ModTime)
+	}
+}
+
+func TestSparse(t *testing.T) {
+	root, err := ioutil.TempDir("", "plakar-sparse-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	const size = 4 * 1024 * 1024
+	data := []byte("data")
+
+	src, err := os.Create(filepath.Join(root, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	src.WriteAt(data, 1024*1024)
+	src.Truncate(size)
+
+	holes, err := ReadHoles(src, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holes == nil {
+		t.Skip("holes not reported by this filesystem")
+	}
+	if holes[0].Offset != 0 || holes[len(holes)-1].Offset+holes[len(holes)-1].Length != size {
+		t.Fatalf("ReadHoles() = %v", holes)
+	}
+
+	content := make([]byte, size)
+	copy(content[1024*1024:], data)
+
+	dst, err := os.Create(filepath.Join(root, "dst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	for offset := 0; offset < size; offset += 1000 * 1000 {
+		end := offset + 1000*1000
+		if end > size {
+			end = size
+		}
+		if err := WriteSparse(dst, content[offset:end], int64(offset), holes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dst.Truncate(size)
+
+	restored, err := ioutil.ReadFile(filepath.Join(root, "dst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(restored) != string(content) {
+		t.Errorf("restored content differs")
+	}
+
+	dstHoles, err := ReadHoles(dst, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dstHoles) != len(holes) {
+		t.Errorf("restored holes %v != %v", dstHoles, holes)
+	}
+}
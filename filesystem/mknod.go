@@ -0,0 +1,10 @@
+//go:build !freebsd
+// +build !freebsd
+
+package filesystem
+
+import "golang.org/x/sys/unix"
+
+func mknod(pathname string, mode uint32, dev uint64) error {
+	return unix.Mknod(pathname, mode, int(dev))
+}
@@ -0,0 +1,8 @@
+package filesystem
+
+import "golang.org/x/sys/unix"
+
+// the device number is 64-bit on FreeBSD
+func mknod(pathname string, mode uint32, dev uint64) error {
+	return unix.Mknod(pathname, mode, dev)
+}
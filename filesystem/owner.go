@@ -0,0 +1,113 @@
+package filesystem
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// user and group databases are looked up once per id or name as scanning
+// and restoring both query them for every pathname
+var muOwnerCache sync.Mutex
+var usernames = make(map[uint64]string)
+var groupnames = make(map[uint64]string)
+var uids = make(map[string]int64)
+var gids = make(map[string]int64)
+
+func lookupUsername(uid uint64) string {
+	muOwnerCache.Lock()
+	defer muOwnerCache.Unlock()
+
+	username, exists := usernames[uid]
+	if !exists {
+		if u, err := user.LookupId(fmt.Sprintf("%d", uid)); err == nil {
+			username = u.Username
+		}
+		usernames[uid] = username
+	}
+	return username
+}
+
+func lookupGroupname(gid uint64) string {
+	muOwnerCache.Lock()
+	defer muOwnerCache.Unlock()
+
+	groupname, exists := groupnames[gid]
+	if !exists {
+		if g, err := user.LookupGroupId(fmt.Sprintf("%d", gid)); err == nil {
+			groupname = g.Name
+		}
+		groupnames[gid] = groupname
+	}
+	return groupname
+}
+
+// lookupUid returns -1 if username is not known on this host.
+func lookupUid(username string) int64 {
+	muOwnerCache.Lock()
+	defer muOwnerCache.Unlock()
+
+	uid, exists := uids[username]
+	if !exists {
+		uid = -1
+		if u, err := user.Lookup(username); err == nil {
+			if id, err := strconv.ParseInt(u.Uid, 10, 64); err == nil {
+				uid = id
+			}
+		}
+		uids[username] = uid
+	}
+	return uid
+}
+
+// lookupGid returns -1 if groupname is not known on this host.
+func lookupGid(groupname string) int64 {
+	muOwnerCache.Lock()
+	defer muOwnerCache.Unlock()
+
+	gid, exists := gids[groupname]
+	if !exists {
+		gid = -1
+		if g, err := user.LookupGroup(groupname); err == nil {
+			if id, err := strconv.ParseInt(g.Gid, 10, 64); err == nil {
+				gid = id
+			}
+		}
+		gids[groupname] = gid
+	}
+	return gid
+}
+
+// Owner returns the uid and gid to restore fileinfo with. Unless numeric
+// is set, the user and group names recorded at scan time are mapped to
+// the ids they have on this host, falling back to the recorded ids for
+// names that are unknown here or were not recorded.
+func (fileinfo *Fileinfo) Owner(numeric bool) (int, int) {
+	uid := int(fileinfo.Uid)
+	gid := int(fileinfo.Gid)
+	if numeric {
+		return uid, gid
+	}
+	if fileinfo.Username != "" {
+		if id := lookupUid(fileinfo.Username); id != -1 {
+			uid = int(id)
+		}
+	}
+	if fileinfo.Groupname != "" {
+		if id := lookupGid(fileinfo.Groupname); id != -1 {
+			gid = int(id)
+		}
+	}
+	return uid, gid
+}
+
+// RestoreTimes sets the modification time recorded for fileinfo on
+// pathname, without following symlinks. The access time is not recorded
+// and is set to the modification time.
+func (fileinfo *Fileinfo) RestoreTimes(pathname string) error {
+	ts := unix.NsecToTimespec(fileinfo.ModTime.UnixNano())
+	return unix.UtimesNanoAt(unix.AT_FDCWD, pathname, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW)
+}
@@ -0,0 +1,35 @@
+package filesystem
+
+import "os"
+
+// Hole is a range of a sparse file that has no data allocated and reads
+// as zeroes.
+type Hole struct {
+	Offset int64
+	Length int64
+}
+
+// WriteSparse writes data at offset in fp, seeking over the ranges that
+// are holes so that they are not allocated. The file must be truncated
+// to its final size once written, in case it ends with a hole.
+func WriteSparse(fp *os.File, data []byte, offset int64, holes []Hole) error {
+	end := offset + int64(len(data))
+	current := offset
+	for _, hole := range holes {
+		holeEnd := hole.Offset + hole.Length
+		if holeEnd <= current || hole.Offset >= end {
+			continue
+		}
+		if hole.Offset > current {
+			if _, err := fp.WriteAt(data[current-offset:hole.Offset-offset], current); err != nil {
+				return err
+			}
+		}
+		current = holeEnd
+		if current >= end {
+			return nil
+		}
+	}
+	_, err := fp.WriteAt(data[current-offset:], current)
+	return err
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package filesystem
+
+import "os"
+
+// ReadHoles returns no holes on systems that can't seek to data and
+// holes, files are stored as a single data extent.
+func ReadHoles(fp *os.File, size int64) ([]Hole, error) {
+	return nil, nil
+}
@@ -0,0 +1,54 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package filesystem
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// ReadHoles returns the holes of a file of the given size, it returns no
+// holes on filesystems that can't report them. The file offset is reset
+// to the start of the file.
+func ReadHoles(fp *os.File, size int64) ([]Hole, error) {
+	defer fp.Seek(0, io.SeekStart)
+
+	holes := make([]Hole, 0)
+	offset := int64(0)
+	for offset < size {
+		data, err := fp.Seek(offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// no data past offset, the file ends with a hole
+			holes = append(holes, Hole{Offset: offset, Length: size - offset})
+			break
+		}
+		if errors.Is(err, unix.EINVAL) {
+			// SEEK_DATA is not supported
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if data >= size {
+			holes = append(holes, Hole{Offset: offset, Length: size - offset})
+			break
+		}
+		if data > offset {
+			holes = append(holes, Hole{Offset: offset, Length: data - offset})
+		}
+
+		offset, err = fp.Seek(data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(holes) == 0 {
+		return nil, nil
+	}
+	return holes, nil
+}
@@ -0,0 +1,34 @@
+package filesystem
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// IsDevice reports whether fileinfo describes a character or block device.
+func (fileinfo *Fileinfo) IsDevice() bool {
+	return fileinfo.Mode&os.ModeDevice != 0
+}
+
+// DeviceNumbers returns the major and minor numbers of a device node.
+func (fileinfo *Fileinfo) DeviceNumbers() (uint32, uint32) {
+	return unix.Major(fileinfo.Rdev), unix.Minor(fileinfo.Rdev)
+}
+
+// Mknod creates the FIFO or device node described by fileinfo at
+// pathname, creating device nodes usually requires root privileges.
+func (fileinfo *Fileinfo) Mknod(pathname string) error {
+	mode := uint32(fileinfo.Mode.Perm())
+	switch {
+	case fileinfo.Mode&os.ModeNamedPipe != 0:
+		return unix.Mkfifo(pathname, mode)
+	case fileinfo.Mode&os.ModeCharDevice != 0:
+		return mknod(pathname, mode|unix.S_IFCHR, fileinfo.Rdev)
+	case fileinfo.Mode&os.ModeDevice != 0:
+		return mknod(pathname, mode|unix.S_IFBLK, fileinfo.Rdev)
+	default:
+		return fmt.Errorf("%s: unsupported file type %s", pathname, fileinfo.Mode.Type())
+	}
+}
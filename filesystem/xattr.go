@@ -0,0 +1,205 @@
+package filesystem
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// POSIX ACLs are exposed by Linux as extended attributes in the system
+// namespace, they are recorded apart from other attributes so that they
+// can be captured and restored independently.
+const (
+	aclXattrPrefix = "system.posix_acl_"
+	aclAccess      = "access"
+	aclDefault     = "default"
+)
+
+// ACL entry tags and version as defined in linux/posix_acl_xattr.h
+const (
+	aclXattrVersion = 2
+	aclUserObj      = 0x01
+	aclUser         = 0x02
+	aclGroupObj     = 0x04
+	aclGroup        = 0x08
+	aclMask         = 0x10
+	aclOther        = 0x20
+)
+
+// readExtendedAttributes records the extended attributes and/or ACLs
+// of pathname into fileinfo, without following symlinks.
+func readExtendedAttributes(pathname string, fileinfo *Fileinfo, xattrs bool, acls bool) error {
+	names, err := listXattrs(pathname)
+	if err != nil {
+		if xattrsUnsupported(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, name := range names {
+		isACL := strings.HasPrefix(name, aclXattrPrefix)
+		if (isACL && !acls) || (!isACL && !xattrs) {
+			continue
+		}
+
+		value, err := getXattr(pathname, name)
+		if err != nil {
+			if err == errNoXattr {
+				// removed since it was listed
+				continue
+			}
+			return fmt.Errorf("%s: %s", name, err)
+		}
+
+		if isACL {
+			if fileinfo.ACLs == nil {
+				fileinfo.ACLs = make(map[string][]byte)
+			}
+			fileinfo.ACLs[strings.TrimPrefix(name, aclXattrPrefix)] = value
+		} else {
+			if fileinfo.Xattrs == nil {
+				fileinfo.Xattrs = make(map[string][]byte)
+			}
+			fileinfo.Xattrs[name] = value
+		}
+	}
+	return nil
+}
+
+// RestoreXattrs sets the extended attributes recorded for fileinfo on
+// pathname. Attributes that can't be set, usually because of missing
+// privileges or filesystem support, are reported but do not stop the
+// others from being restored.
+func (fileinfo *Fileinfo) RestoreXattrs(pathname string) error {
+	failed := make([]string, 0)
+	for _, name := range sortedKeys(fileinfo.Xattrs) {
+		if err := setXattr(pathname, name, fileinfo.Xattrs[name]); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("could not restore xattrs: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// RestoreACLs sets the POSIX ACLs recorded for fileinfo on pathname,
+// this must happen after permissions are set as a chmod alters them.
+func (fileinfo *Fileinfo) RestoreACLs(pathname string) error {
+	failed := make([]string, 0)
+	for _, name := range sortedKeys(fileinfo.ACLs) {
+		if err := setXattr(pathname, aclXattrPrefix+name, fileinfo.ACLs[name]); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("could not restore ACLs: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// FormatXattr returns a printable representation of an attribute value,
+// binary values are hex-encoded.
+func FormatXattr(value []byte) string {
+	printable := true
+	for _, r := range string(bytes.TrimRight(value, "\x00")) {
+		if r == unicode.ReplacementChar || !unicode.IsPrint(r) {
+			printable = false
+			break
+		}
+	}
+	if printable {
+		return fmt.Sprintf("%q", bytes.TrimRight(value, "\x00"))
+	}
+	return fmt.Sprintf("0x%x", value)
+}
+
+// FormatACL returns the short text form of an ACL as output by getfacl,
+// e.g. "user::rw-,group::r--,other::r--".
+func FormatACL(value []byte) string {
+	if len(value) < 4 || (len(value)-4)%8 != 0 ||
+		binary.LittleEndian.Uint32(value[0:4]) != aclXattrVersion {
+		return FormatXattr(value)
+	}
+
+	entries := make([]string, 0)
+	for offset := 4; offset < len(value); offset += 8 {
+		tag := binary.LittleEndian.Uint16(value[offset:])
+		perm := binary.LittleEndian.Uint16(value[offset+2:])
+		id := binary.LittleEndian.Uint32(value[offset+4:])
+
+		perms := []byte("---")
+		if perm&4 != 0 {
+			perms[0] = 'r'
+		}
+		if perm&2 != 0 {
+			perms[1] = 'w'
+		}
+		if perm&1 != 0 {
+			perms[2] = 'x'
+		}
+
+		var qualifier string
+		switch tag {
+		case aclUserObj:
+			qualifier = "user:"
+		case aclUser:
+			qualifier = fmt.Sprintf("user:%d", id)
+		case aclGroupObj:
+			qualifier = "group:"
+		case aclGroup:
+			qualifier = fmt.Sprintf("group:%d", id)
+		case aclMask:
+			qualifier = "mask:"
+		case aclOther:
+			qualifier = "other:"
+		default:
+			qualifier = fmt.Sprintf("%#x:%d", tag, id)
+		}
+		entries = append(entries, qualifier+":"+string(perms))
+	}
+	return strings.Join(entries, ",")
+}
+
+// Equal reports whether two fileinfos describe the same inode state,
+// including extended attributes and ACLs.
+func (fileinfo *Fileinfo) Equal(other *Fileinfo) bool {
+	return fileinfo.Name == other.Name &&
+		fileinfo.Size == other.Size &&
+		fileinfo.Mode == other.Mode &&
+		fileinfo.ModTime.Equal(other.ModTime) &&
+		fileinfo.Dev == other.Dev &&
+		fileinfo.Ino == other.Ino &&
+		fileinfo.Uid == other.Uid &&
+		fileinfo.Gid == other.Gid &&
+		fileinfo.Rdev == other.Rdev &&
+		fileinfo.Username == other.Username &&
+		fileinfo.Groupname == other.Groupname &&
+		equalAttributes(fileinfo.Xattrs, other.Xattrs) &&
+		equalAttributes(fileinfo.ACLs, other.ACLs)
+}
+
+func equalAttributes(a map[string][]byte, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if other, exists := b[name]; !exists || !bytes.Equal(value, other) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,6 @@
+package filesystem
+
+import "golang.org/x/sys/unix"
+
+// errNoXattr is returned when reading an attribute that doesn't exist
+var errNoXattr = unix.ENOATTR
@@ -0,0 +1,29 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package filesystem
+
+import "errors"
+
+// extended attributes and ACLs are neither recorded nor restored on
+// systems without an implementation
+
+var errNoXattr = errors.New("no such attribute")
+
+var errXattrUnsupported = errors.New("extended attributes are not supported on this system")
+
+func listXattrs(pathname string) ([]string, error) {
+	return nil, nil
+}
+
+func getXattr(pathname string, name string) ([]byte, error) {
+	return nil, errNoXattr
+}
+
+func setXattr(pathname string, name string, value []byte) error {
+	return errXattrUnsupported
+}
+
+func xattrsUnsupported(err error) bool {
+	return err == errXattrUnsupported
+}
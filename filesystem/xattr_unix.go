@@ -0,0 +1,66 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package filesystem
+
+import (
+	"bytes"
+
+	"golang.org/x/sys/unix"
+)
+
+func listXattrs(pathname string) ([]string, error) {
+	size, err := unix.Llistxattr(pathname, nil)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		buf := make([]byte, size)
+		size, err = unix.Llistxattr(pathname, buf)
+		if err == unix.ERANGE {
+			// attributes were added since the size was queried
+			size, err = unix.Llistxattr(pathname, nil)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		names := make([]string, 0)
+		for _, name := range bytes.Split(buf[:size], []byte{0}) {
+			if len(name) != 0 {
+				names = append(names, string(name))
+			}
+		}
+		return names, nil
+	}
+}
+
+func getXattr(pathname string, name string) ([]byte, error) {
+	for {
+		size, err := unix.Lgetxattr(pathname, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		size, err = unix.Lgetxattr(pathname, name, buf)
+		if err == unix.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:size], nil
+	}
+}
+
+func xattrsUnsupported(err error) bool {
+	return err == unix.ENOTSUP || err == unix.EOPNOTSUPP
+}
+
+func setXattr(pathname string, name string, value []byte) error {
+	return unix.Lsetxattr(pathname, name, value, 0)
+}
@@ -11,11 +11,13 @@ require (
 	github.com/google/uuid v1.3.0
 	github.com/gorilla/mux v1.8.0
 	github.com/iafan/cwalk v0.0.0-20210125030640-586a8832a711
+	github.com/klauspost/compress v1.13.6
 	github.com/mattn/go-sqlite3 v1.14.9
+	github.com/pierrec/lz4/v4 v4.1.12
 	github.com/pmezard/go-difflib v1.0.0
 	github.com/poolpOrg/go-fastcdc v0.0.0-20211130115626-1f6e826f4a2f
 	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
-	golang.org/x/sys v0.0.0-20210616094352-59db8d763f22 // indirect
+	golang.org/x/sys v0.0.0-20210616094352-59db8d763f22
 	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
 )
@@ -2,6 +2,7 @@ package network
 
 import (
 	"encoding/gob"
+	"encoding/json"
 	"io"
 	"log"
 	"net"
@@ -75,7 +76,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 				result := Request{
 					Uuid:    request.Uuid,
 					Type:    "ResCreate",
-					Payload: ResCreate{Err: err},
+					Payload: ResCreate{Err: NewError(err)},
 				}
 				err = encoder.Encode(&result)
 				if err != nil {
@@ -97,7 +98,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 				repository, err = storage.Open(dirPath)
 				var payload ResOpen
 				if err != nil {
-					payload = ResOpen{RepositoryConfig: nil, Err: err}
+					payload = ResOpen{RepositoryConfig: nil, Err: NewError(err)}
 				} else {
 					config := repository.Configuration()
 					payload = ResOpen{RepositoryConfig: &config, Err: nil}
@@ -113,6 +114,25 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 				}
 			}()
 
+		case "ReqSetConfiguration":
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				logger.Trace("%s: SetConfiguration()", clientUuid)
+				err := repository.SetConfiguration(request.Payload.(ReqSetConfiguration).RepositoryConfig)
+				result := Request{
+					Uuid: request.Uuid,
+					Type: "ResSetConfiguration",
+					Payload: ResSetConfiguration{
+						Err: NewError(err),
+					},
+				}
+				err = encoder.Encode(&result)
+				if err != nil {
+					logger.Warn("%s", err)
+				}
+			}()
+
 		case "ReqGetIndexes":
 			wg.Add(1)
 			go func() {
@@ -124,7 +144,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Type: "ResGetIndexes",
 					Payload: ResGetIndexes{
 						Indexes: indexes,
-						Err:     err,
+						Err:     NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -144,7 +164,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Type: "ResGetChunks",
 					Payload: ResGetChunks{
 						Chunks: chunks,
-						Err:    err,
+						Err:    NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -164,7 +184,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Type: "ResGetObjects",
 					Payload: ResGetObjects{
 						Objects: objects,
-						Err:     err,
+						Err:     NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -184,7 +204,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Type: "ResGetMetadata",
 					Payload: ResGetMetadata{
 						Data: data,
-						Err:  err,
+						Err:  NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -204,7 +224,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Type: "ResGetIndex",
 					Payload: ResGetIndex{
 						Data: data,
-						Err:  err,
+						Err:  NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -224,7 +244,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Type: "ResGetObject",
 					Payload: ResGetObject{
 						Data: data,
-						Err:  err,
+						Err:  NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -245,7 +265,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Type: "ResGetChunk",
 					Payload: ResGetChunk{
 						Data: data,
-						Err:  err,
+						Err:  NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -266,7 +286,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Type: "ResCheckObject",
 					Payload: ResCheckObject{
 						Exists: exists,
-						Err:    err,
+						Err:    NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -287,7 +307,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Type: "ResCheckChunk",
 					Payload: ResCheckChunk{
 						Exists: exists,
-						Err:    err,
+						Err:    NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -307,7 +327,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Type: "ResGetChunkRefCount",
 					Payload: ResGetChunkRefCount{
 						RefCount: refCount,
-						Err:      err,
+						Err:      NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -327,7 +347,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Type: "ResGetObjectRefCount",
 					Payload: ResGetObjectRefCount{
 						RefCount: refCount,
-						Err:      err,
+						Err:      NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -347,7 +367,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Type: "ResGetChunkSize",
 					Payload: ResGetChunkSize{
 						Size: size,
-						Err:  err,
+						Err:  NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -367,7 +387,132 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Type: "ResGetObjectSize",
 					Payload: ResGetObjectSize{
 						Size: size,
-						Err:  err,
+						Err:  NewError(err),
+					},
+				}
+				err = encoder.Encode(&result)
+				if err != nil {
+					logger.Warn("%s", err)
+				}
+			}()
+
+		case "ReqDeleteObject":
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				logger.Trace("%s: DeleteObject(%s)", clientUuid, request.Payload.(ReqDeleteObject).Checksum)
+				deleted, err := repository.DeleteObject(request.Payload.(ReqDeleteObject).Checksum)
+				result := Request{
+					Uuid: request.Uuid,
+					Type: "ResDeleteObject",
+					Payload: ResDeleteObject{
+						Deleted: deleted,
+						Err:     NewError(err),
+					},
+				}
+				err = encoder.Encode(&result)
+				if err != nil {
+					logger.Warn("%s", err)
+				}
+			}()
+
+		case "ReqDeleteChunk":
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				logger.Trace("%s: DeleteChunk(%s)", clientUuid, request.Payload.(ReqDeleteChunk).Checksum)
+				deleted, err := repository.DeleteChunk(request.Payload.(ReqDeleteChunk).Checksum)
+				result := Request{
+					Uuid: request.Uuid,
+					Type: "ResDeleteChunk",
+					Payload: ResDeleteChunk{
+						Deleted: deleted,
+						Err:     NewError(err),
+					},
+				}
+				err = encoder.Encode(&result)
+				if err != nil {
+					logger.Warn("%s", err)
+				}
+			}()
+
+		case "ReqGetHold":
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				logger.Trace("%s: GetHold(%s)", clientUuid, request.Payload.(ReqGetHold).Uuid)
+				var data []byte
+				hold, err := repository.GetHold(request.Payload.(ReqGetHold).Uuid)
+				if err == nil && hold != nil {
+					data, err = json.Marshal(hold)
+				}
+				result := Request{
+					Uuid: request.Uuid,
+					Type: "ResGetHold",
+					Payload: ResGetHold{
+						Data: data,
+						Err:  NewError(err),
+					},
+				}
+				err = encoder.Encode(&result)
+				if err != nil {
+					logger.Warn("%s", err)
+				}
+			}()
+
+		case "ReqPutHold":
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				logger.Trace("%s: PutHold(%s)", clientUuid, request.Payload.(ReqPutHold).Uuid)
+				hold := storage.Hold{}
+				err := json.Unmarshal(request.Payload.(ReqPutHold).Data, &hold)
+				if err == nil {
+					err = repository.PutHold(request.Payload.(ReqPutHold).Uuid, hold)
+				}
+				result := Request{
+					Uuid: request.Uuid,
+					Type: "ResPutHold",
+					Payload: ResPutHold{
+						Err: NewError(err),
+					},
+				}
+				err = encoder.Encode(&result)
+				if err != nil {
+					logger.Warn("%s", err)
+				}
+			}()
+
+		case "ReqPutSnapshotMetadata":
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				logger.Trace("%s: PutMetadata(%s)", clientUuid, request.Payload.(ReqPutSnapshotMetadata).Uuid)
+				err := repository.PutMetadata(request.Payload.(ReqPutSnapshotMetadata).Uuid, request.Payload.(ReqPutSnapshotMetadata).Data)
+				result := Request{
+					Uuid: request.Uuid,
+					Type: "ResPutSnapshotMetadata",
+					Payload: ResPutSnapshotMetadata{
+						Err: NewError(err),
+					},
+				}
+				err = encoder.Encode(&result)
+				if err != nil {
+					logger.Warn("%s", err)
+				}
+			}()
+
+		case "ReqDeleteHold":
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				logger.Trace("%s: DeleteHold(%s)", clientUuid, request.Payload.(ReqDeleteHold).Uuid)
+				err := repository.DeleteHold(request.Payload.(ReqDeleteHold).Uuid)
+				result := Request{
+					Uuid: request.Uuid,
+					Type: "ResDeleteHold",
+					Payload: ResDeleteHold{
+						Err: NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -387,7 +532,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Uuid: request.Uuid,
 					Type: "ResPurge",
 					Payload: ResPurge{
-						Err: err,
+						Err: NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -403,19 +548,107 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 
 				logger.Trace("%s: Transaction", clientUuid)
 				tx, err := repository.Transaction()
+				payload := ResTransaction{Err: NewError(err)}
+				if err == nil {
+					payload.Uuid = tx.GetUuid()
+				}
+				result := Request{
+					Uuid:    request.Uuid,
+					Type:    "ResTransaction",
+					Payload: payload,
+				}
+				err = encoder.Encode(&result)
+				if err != nil {
+					logger.Warn("%s", err)
+				}
+				if tx != nil {
+					transactions[tx.GetUuid()] = tx
+				}
+			}()
+
+		case "ReqGetTransactions":
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				logger.Trace("%s: GetTransactions", clientUuid)
+				txs, err := repository.GetTransactions()
 				result := Request{
 					Uuid: request.Uuid,
-					Type: "ResTransaction",
-					Payload: ResTransaction{
-						Uuid: tx.GetUuid(),
-						Err:  err,
+					Type: "ResGetTransactions",
+					Payload: ResGetTransactions{
+						Transactions: txs,
+						Err:          NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
 				if err != nil {
 					logger.Warn("%s", err)
 				}
-				transactions[tx.GetUuid()] = tx
+			}()
+
+		case "ReqGetTransactionMetadata":
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				logger.Trace("%s: GetTransactionMetadata(%s)", clientUuid, request.Payload.(ReqGetTransactionMetadata).Uuid)
+				data, err := repository.GetTransactionMetadata(request.Payload.(ReqGetTransactionMetadata).Uuid)
+				result := Request{
+					Uuid: request.Uuid,
+					Type: "ResGetTransactionMetadata",
+					Payload: ResGetTransactionMetadata{
+						Data: data,
+						Err:  NewError(err),
+					},
+				}
+				err = encoder.Encode(&result)
+				if err != nil {
+					logger.Warn("%s", err)
+				}
+			}()
+
+		case "ReqGetTransactionIndex":
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				logger.Trace("%s: GetTransactionIndex(%s)", clientUuid, request.Payload.(ReqGetTransactionIndex).Uuid)
+				data, err := repository.GetTransactionIndex(request.Payload.(ReqGetTransactionIndex).Uuid)
+				result := Request{
+					Uuid: request.Uuid,
+					Type: "ResGetTransactionIndex",
+					Payload: ResGetTransactionIndex{
+						Data: data,
+						Err:  NewError(err),
+					},
+				}
+				err = encoder.Encode(&result)
+				if err != nil {
+					logger.Warn("%s", err)
+				}
+			}()
+
+		case "ReqResumeTransaction":
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				logger.Trace("%s: ResumeTransaction(%s)", clientUuid, request.Payload.(ReqResumeTransaction).Uuid)
+				tx, err := repository.ResumeTransaction(request.Payload.(ReqResumeTransaction).Uuid)
+				payload := ResResumeTransaction{Err: NewError(err)}
+				if err == nil {
+					payload.Uuid = tx.GetUuid()
+				}
+				result := Request{
+					Uuid:    request.Uuid,
+					Type:    "ResResumeTransaction",
+					Payload: payload,
+				}
+				err = encoder.Encode(&result)
+				if err != nil {
+					logger.Warn("%s", err)
+				}
+				if tx != nil {
+					transactions[tx.GetUuid()] = tx
+				}
 			}()
 
 		case "ReqReferenceChunks":
@@ -432,7 +665,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Type: "ResReferenceChunks",
 					Payload: ResReferenceChunks{
 						Exists: exists,
-						Err:    err,
+						Err:    NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -455,7 +688,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Type: "ResReferenceObjects",
 					Payload: ResReferenceObjects{
 						Exists: exists,
-						Err:    err,
+						Err:    NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -477,7 +710,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Uuid: request.Uuid,
 					Type: "ResPutChunk",
 					Payload: ResPutChunk{
-						Err: err,
+						Err: NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -499,7 +732,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Uuid: request.Uuid,
 					Type: "ResPutObject",
 					Payload: ResPutObject{
-						Err: err,
+						Err: NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -520,7 +753,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Uuid: request.Uuid,
 					Type: "ResPutMetadata",
 					Payload: ResPutMetadata{
-						Err: err,
+						Err: NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -541,7 +774,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Uuid: request.Uuid,
 					Type: "ResPutIndex",
 					Payload: ResPutIndex{
-						Err: err,
+						Err: NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -563,7 +796,67 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Uuid: request.Uuid,
 					Type: "ResCommit",
 					Payload: ResCommit{
-						Err: err,
+						Err: NewError(err),
+					},
+				}
+				err = encoder.Encode(&result)
+				if err != nil {
+					logger.Warn("%s", err)
+				}
+			}()
+
+		case "ReqRollback":
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				logger.Trace("%s: Rollback()", clientUuid)
+				txUuid := request.Payload.(ReqRollback).Transaction
+				tx := transactions[txUuid]
+				err := tx.Rollback()
+				result := Request{
+					Uuid: request.Uuid,
+					Type: "ResRollback",
+					Payload: ResRollback{
+						Err: NewError(err),
+					},
+				}
+				err = encoder.Encode(&result)
+				if err != nil {
+					logger.Warn("%s", err)
+				}
+			}()
+
+		case "ReqLock":
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				logger.Trace("%s: Lock(%t)", clientUuid, request.Payload.(ReqLock).Exclusive)
+				err := repository.Lock(request.Payload.(ReqLock).Exclusive)
+				result := Request{
+					Uuid: request.Uuid,
+					Type: "ResLock",
+					Payload: ResLock{
+						Err: NewError(err),
+					},
+				}
+				err = encoder.Encode(&result)
+				if err != nil {
+					logger.Warn("%s", err)
+				}
+			}()
+
+		case "ReqUnlock":
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				logger.Trace("%s: Unlock()", clientUuid)
+				err := repository.Unlock()
+				result := Request{
+					Uuid: request.Uuid,
+					Type: "ResUnlock",
+					Payload: ResUnlock{
+						Err: NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
@@ -584,7 +877,7 @@ func handleConnection(rd io.Reader, wr io.Writer) {
 					Uuid: request.Uuid,
 					Type: "ResClose",
 					Payload: ResClose{
-						Err: err,
+						Err: NewError(err),
 					},
 				}
 				err = encoder.Encode(&result)
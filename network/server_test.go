@@ -0,0 +1,73 @@
+package network
+
+import (
+	"encoding/gob"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/poolpOrg/plakar/storage"
+	_ "github.com/poolpOrg/plakar/storage/fs"
+)
+
+func TestServerErrors(t *testing.T) {
+	ProtocolRegister()
+
+	dirPath := filepath.Join(t.TempDir(), "plakar")
+	repository, err := storage.Create(dirPath, storage.RepositoryConfig{Uuid: uuid.NewString()})
+	if err != nil {
+		t.Fatalf("Create(%s): %s", dirPath, err)
+	}
+	repository.Close()
+
+	clientRd, serverWr := io.Pipe()
+	serverRd, clientWr := io.Pipe()
+	go handleConnection(serverRd, serverWr)
+	defer clientWr.Close()
+
+	encoder := gob.NewEncoder(clientWr)
+	decoder := gob.NewDecoder(clientRd)
+
+	// requests are answered concurrently, waiting for each reply keeps them
+	// in order
+	roundTrip := func(request Request) Request {
+		result := make(chan Request)
+		go func() {
+			if err := encoder.Encode(&request); err != nil {
+				t.Errorf("Encode(%s): %s", request.Type, err)
+			}
+			response := Request{}
+			if err := decoder.Decode(&response); err != nil {
+				t.Errorf("Decode(%s): %s", request.Type, err)
+			}
+			result <- response
+		}()
+		select {
+		case response := <-result:
+			return response
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: no reply from server", request.Type)
+		}
+		return Request{}
+	}
+
+	response := roundTrip(Request{Uuid: uuid.NewString(), Type: "ReqOpen", Payload: ReqOpen{Repository: dirPath}})
+	if err := response.Payload.(ResOpen).Err; err != nil {
+		t.Fatalf("Open(%s): %s", dirPath, err)
+	}
+
+	// a transaction that doesn't exist fails with an *fs.PathError, which
+	// gob can't encode as is
+	missing := uuid.NewString()
+	response = roundTrip(Request{Uuid: uuid.NewString(), Type: "ReqGetTransactionMetadata", Payload: ReqGetTransactionMetadata{Uuid: missing}})
+	if response.Payload.(ResGetTransactionMetadata).Err == nil {
+		t.Errorf("GetTransactionMetadata(%s): expected an error", missing)
+	}
+
+	response = roundTrip(Request{Uuid: uuid.NewString(), Type: "ReqResumeTransaction", Payload: ReqResumeTransaction{Uuid: missing}})
+	if response.Payload.(ResResumeTransaction).Err == nil {
+		t.Errorf("ResumeTransaction(%s): expected an error", missing)
+	}
+}
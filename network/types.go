@@ -30,6 +30,14 @@ type ResOpen struct {
 	Err              error
 }
 
+type ReqSetConfiguration struct {
+	RepositoryConfig storage.RepositoryConfig
+}
+
+type ResSetConfiguration struct {
+	Err error
+}
+
 type ReqGetChunks struct {
 }
 
@@ -116,6 +124,41 @@ type ResPurge struct {
 	Err error
 }
 
+type ReqGetHold struct {
+	Uuid string
+}
+
+type ResGetHold struct {
+	Data []byte
+	Err  error
+}
+
+type ReqPutHold struct {
+	Uuid string
+	Data []byte
+}
+
+type ResPutHold struct {
+	Err error
+}
+
+type ReqPutSnapshotMetadata struct {
+	Uuid string
+	Data []byte
+}
+
+type ResPutSnapshotMetadata struct {
+	Err error
+}
+
+type ReqDeleteHold struct {
+	Uuid string
+}
+
+type ResDeleteHold struct {
+	Err error
+}
+
 type ReqClose struct {
 	Uuid string
 }
@@ -132,6 +175,41 @@ type ResTransaction struct {
 	Err  error
 }
 
+type ReqGetTransactions struct {
+}
+
+type ResGetTransactions struct {
+	Transactions []string
+	Err          error
+}
+
+type ReqGetTransactionMetadata struct {
+	Uuid string
+}
+
+type ResGetTransactionMetadata struct {
+	Data []byte
+	Err  error
+}
+
+type ReqGetTransactionIndex struct {
+	Uuid string
+}
+
+type ResGetTransactionIndex struct {
+	Data []byte
+	Err  error
+}
+
+type ReqResumeTransaction struct {
+	Uuid string
+}
+
+type ResResumeTransaction struct {
+	Uuid string
+	Err  error
+}
+
 type ReqReferenceChunks struct {
 	Transaction string
 	Keys        []string
@@ -198,6 +276,14 @@ type ResCommit struct {
 	Err error
 }
 
+type ReqRollback struct {
+	Transaction string
+}
+
+type ResRollback struct {
+	Err error
+}
+
 type ReqGetChunkRefCount struct {
 	Checksum string
 }
@@ -234,6 +320,56 @@ type ResGetChunkSize struct {
 	Err  error
 }
 
+type ReqLock struct {
+	Exclusive bool
+}
+
+type ResLock struct {
+	Err error
+}
+
+type ReqUnlock struct {
+}
+
+type ResUnlock struct {
+	Err error
+}
+
+// Error carries an error over the wire, gob can't encode the error types
+// returned by backends.
+type Error struct {
+	Message string
+}
+
+func (err Error) Error() string {
+	return err.Message
+}
+
+func NewError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return Error{Message: err.Error()}
+}
+
+type ReqDeleteObject struct {
+	Checksum string
+}
+
+type ResDeleteObject struct {
+	Deleted bool
+	Err     error
+}
+
+type ReqDeleteChunk struct {
+	Checksum string
+}
+
+type ResDeleteChunk struct {
+	Deleted bool
+	Err     error
+}
+
 func ProtocolRegister() {
 	gob.Register(Request{})
 
@@ -243,6 +379,9 @@ func ProtocolRegister() {
 	gob.Register(ReqOpen{})
 	gob.Register(ResOpen{})
 
+	gob.Register(ReqSetConfiguration{})
+	gob.Register(ResSetConfiguration{})
+
 	gob.Register(ReqGetIndexes{})
 	gob.Register(ResGetIndexes{})
 
@@ -273,12 +412,36 @@ func ProtocolRegister() {
 	gob.Register(ReqPurge{})
 	gob.Register(ResPurge{})
 
+	gob.Register(ReqGetHold{})
+	gob.Register(ResGetHold{})
+
+	gob.Register(ReqPutHold{})
+	gob.Register(ResPutHold{})
+
+	gob.Register(ReqPutSnapshotMetadata{})
+	gob.Register(ResPutSnapshotMetadata{})
+
+	gob.Register(ReqDeleteHold{})
+	gob.Register(ResDeleteHold{})
+
 	gob.Register(ReqClose{})
 	gob.Register(ResClose{})
 
 	gob.Register(ReqTransaction{})
 	gob.Register(ResTransaction{})
 
+	gob.Register(ReqGetTransactions{})
+	gob.Register(ResGetTransactions{})
+
+	gob.Register(ReqGetTransactionMetadata{})
+	gob.Register(ResGetTransactionMetadata{})
+
+	gob.Register(ReqGetTransactionIndex{})
+	gob.Register(ResGetTransactionIndex{})
+
+	gob.Register(ReqResumeTransaction{})
+	gob.Register(ResResumeTransaction{})
+
 	gob.Register(ReqReferenceChunks{})
 	gob.Register(ResReferenceChunks{})
 
@@ -300,6 +463,9 @@ func ProtocolRegister() {
 	gob.Register(ReqCommit{})
 	gob.Register(ResCommit{})
 
+	gob.Register(ReqRollback{})
+	gob.Register(ResRollback{})
+
 	gob.Register(ReqGetChunkRefCount{})
 	gob.Register(ResGetChunkRefCount{})
 
@@ -311,4 +477,18 @@ func ProtocolRegister() {
 
 	gob.Register(ReqGetObjectSize{})
 	gob.Register(ResGetObjectSize{})
+
+	gob.Register(ReqLock{})
+	gob.Register(ResLock{})
+
+	gob.Register(ReqUnlock{})
+	gob.Register(ResUnlock{})
+
+	gob.Register(Error{})
+
+	gob.Register(ReqDeleteObject{})
+	gob.Register(ResDeleteObject{})
+
+	gob.Register(ReqDeleteChunk{})
+	gob.Register(ResDeleteChunk{})
 }
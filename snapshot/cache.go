@@ -15,6 +15,11 @@ func (snapshot *Snapshot) GetCachedObject(pathname string) (*CachedObject, error
 	secret := snapshot.repository.GetSecret()
 	cache := snapshot.repository.GetCache()
 
+	// cached objects can't be read back in write-only mode
+	if snapshot.repository.Configuration().Encryption != "" && secret == nil {
+		return nil, fmt.Errorf("cache unavailable in write-only mode")
+	}
+
 	pathHash := sha256.New()
 	pathHash.Write([]byte(pathname))
 	hashedPath := fmt.Sprintf("%032x", pathHash.Sum(nil))
@@ -27,7 +32,7 @@ func (snapshot *Snapshot) GetCachedObject(pathname string) (*CachedObject, error
 	logger.Trace("%s: cache.GetPath(%s): OK", snapshot.Metadata.Uuid, pathname)
 
 	if snapshot.repository.Configuration().Encryption != "" {
-		tmp, err := encryption.Decrypt(secret, data)
+		tmp, err := encryption.Decrypt(secret, data, encryption.AssociatedData("cache", hashedPath))
 		if err != nil {
 			return nil, err
 		}
@@ -52,6 +57,10 @@ func (snapshot *Snapshot) PutCachedObject(pathname string, object Object, fi fil
 	secret := snapshot.repository.GetSecret()
 	cache := snapshot.repository.GetCache()
 
+	if snapshot.repository.Configuration().Encryption != "" && secret == nil {
+		return nil
+	}
+
 	pathHash := sha256.New()
 	pathHash.Write([]byte(pathname))
 	hashedPath := fmt.Sprintf("%032x", pathHash.Sum(nil))
@@ -66,6 +75,7 @@ func (snapshot *Snapshot) PutCachedObject(pathname string, object Object, fi fil
 	}
 
 	cacheObject.ContentType = object.ContentType
+	cacheObject.Holes = object.Holes
 	cacheObject.Info = fi
 
 	jobject, err := json.Marshal(cacheObject)
@@ -73,9 +83,17 @@ func (snapshot *Snapshot) PutCachedObject(pathname string, object Object, fi fil
 		return err
 	}
 
-	jobject = compression.Deflate(jobject)
+	codec := snapshot.repository.Configuration().Compression
+	if codec == "" {
+		codec = "none"
+	}
+	jobject, err = compression.Deflate(codec, jobject)
+	if err != nil {
+		return err
+	}
+
 	if snapshot.repository.Configuration().Encryption != "" {
-		tmp, err := encryption.Encrypt(secret, jobject)
+		tmp, err := encryption.Encrypt(secret, jobject, encryption.AssociatedData("cache", hashedPath))
 		if err != nil {
 			return err
 		}
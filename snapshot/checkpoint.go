@@ -0,0 +1,163 @@
+package snapshot
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/poolpOrg/plakar/compression"
+	"github.com/poolpOrg/plakar/encryption"
+	"github.com/poolpOrg/plakar/filesystem"
+	"github.com/poolpOrg/plakar/logger"
+	"github.com/poolpOrg/plakar/storage"
+)
+
+// Checkpoint stores the metadata and index of a push in progress within
+// its transaction, so that an interrupted push can be resumed without
+// processing again the files it already stored. It must not be called
+// while the index is being updated.
+func (snapshot *Snapshot) Checkpoint() error {
+	serializedIndex, err := indexToBytes(snapshot.Index)
+	if err != nil {
+		return err
+	}
+	serializedMetadata, err := metadataToBytes(snapshot.Metadata)
+	if err != nil {
+		return err
+	}
+
+	// the index goes first as the metadata is what identifies a
+	// transaction as resumable
+	err = snapshot.PutIndex(serializedIndex)
+	if err != nil {
+		return err
+	}
+	err = snapshot.PutMetadata(serializedMetadata)
+	if err != nil {
+		return err
+	}
+
+	logger.Trace("%s: Checkpoint()", snapshot.Metadata.Uuid)
+	return nil
+}
+
+func openCheckpointBlob(repository *storage.Repository, kind string, Uuid string, buffer []byte) ([]byte, error) {
+	if secret := repository.GetSecret(); secret != nil {
+		tmp, err := encryption.Decrypt(secret, buffer, encryption.AssociatedData(kind, Uuid))
+		if err != nil {
+			return nil, err
+		}
+		buffer = tmp
+	} else if repository.GetPublicKey() != nil {
+		return nil, fmt.Errorf("%s: checkpoints can't be read in write-only mode", Uuid)
+	}
+
+	if repository.Configuration().Compression != "" {
+		return compression.Inflate(buffer)
+	}
+	return buffer, nil
+}
+
+// ListCheckpoints returns the metadata of interrupted pushes that can be
+// resumed, most recent first.
+func ListCheckpoints(repository *storage.Repository) ([]*Metadata, error) {
+	transactions, err := repository.GetTransactions()
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoints := make([]*Metadata, 0)
+	for _, Uuid := range transactions {
+		buffer, err := repository.GetTransactionMetadata(Uuid)
+		if err != nil {
+			// interrupted before its first checkpoint
+			logger.Trace("snapshot: transaction %s has no checkpoint: %s", Uuid, err)
+			continue
+		}
+		buffer, err = openCheckpointBlob(repository, "metadata", Uuid, buffer)
+		if err != nil {
+			return nil, err
+		}
+		metadata, err := metadataFromBytes(buffer)
+		if err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, metadata)
+	}
+
+	sort.Slice(checkpoints, func(i, j int) bool {
+		return checkpoints[i].CreationTime.After(checkpoints[j].CreationTime)
+	})
+	return checkpoints, nil
+}
+
+// Resume reopens the transaction of an interrupted push. Files that did
+// not change since its last checkpoint reuse the objects it recorded,
+// whose chunks are already stored.
+func Resume(repository *storage.Repository, Uuid string) (*Snapshot, error) {
+	buffer, err := repository.GetTransactionIndex(Uuid)
+	if err != nil {
+		return nil, err
+	}
+	buffer, err = openCheckpointBlob(repository, "index", Uuid, buffer)
+	if err != nil {
+		return nil, err
+	}
+	index, err := indexFromBytes(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := repository.ResumeTransaction(Uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := newSnapshot(repository, tx)
+	snapshot.checkpoint = index
+
+	logger.Trace("%s: Resume()", snapshot.Metadata.Uuid)
+	return snapshot, nil
+}
+
+func pathnameCheckpointed(snapshot *Snapshot, fi filesystem.Fileinfo, pathname string) (*Object, error) {
+	if snapshot.checkpoint == nil {
+		return nil, nil
+	}
+
+	checksum, exists := snapshot.checkpoint.Pathnames[pathname]
+	if !exists {
+		return nil, nil
+	}
+	checkpointed, exists := snapshot.checkpoint.Filesystem.LookupInodeForFile(pathname)
+	if !exists {
+		return nil, nil
+	}
+	if checkpointed.Mode != fi.Mode || checkpointed.Dev != fi.Dev || checkpointed.Ino != fi.Ino ||
+		checkpointed.Size != fi.Size || !checkpointed.ModTime.Equal(fi.ModTime) {
+		return nil, nil
+	}
+	object, exists := snapshot.checkpoint.Objects[checksum]
+	if !exists {
+		return nil, nil
+	}
+
+	res, err := snapshot.ReferenceChunks(object.Chunks)
+	if err != nil {
+		return nil, err
+	}
+	for _, exists := range res {
+		if !exists {
+			return nil, nil
+		}
+	}
+
+	snapshot.Index.muChunks.Lock()
+	for _, chunkChecksum := range object.Chunks {
+		if chunk, exists := snapshot.checkpoint.Chunks[chunkChecksum]; exists {
+			snapshot.Index.Chunks[chunkChecksum] = chunk
+		}
+	}
+	snapshot.Index.muChunks.Unlock()
+
+	return object, nil
+}
@@ -0,0 +1,114 @@
+package snapshot
+
+import (
+	"github.com/poolpOrg/plakar/logger"
+	"github.com/poolpOrg/plakar/storage"
+)
+
+type GCReport struct {
+	Objects     uint64
+	ObjectsSize uint64
+	Chunks      uint64
+	ChunksSize  uint64
+
+	// unreferenced by snapshots but still used by a transaction
+	InUse uint64
+}
+
+// GarbageCollect marks every object and chunk referenced by a snapshot
+// index, then sweeps the others from the repository. Blobs that belong to
+// a push in progress or to an interrupted one are left to the backend to
+// protect, they are kept until the transaction is committed or cleaned up.
+// A dry run asks the backend for their references instead of deleting them.
+func GarbageCollect(repository *storage.Repository, dryRun bool) (*GCReport, error) {
+	indexes, err := repository.GetIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	// an index that can't be read makes the mark phase incomplete, in
+	// which case nothing can be safely swept
+	markedObjects := make(map[string]bool)
+	markedChunks := make(map[string]bool)
+	for _, Uuid := range indexes {
+		index, _, err := GetIndex(repository, Uuid)
+		if err != nil {
+			return nil, err
+		}
+		for checksum, object := range index.Objects {
+			markedObjects[checksum] = true
+			for _, chunkChecksum := range object.Chunks {
+				markedChunks[chunkChecksum] = true
+			}
+		}
+		for checksum := range index.Chunks {
+			markedChunks[checksum] = true
+		}
+	}
+
+	report := &GCReport{}
+
+	objects, err := repository.GetObjects()
+	if err != nil {
+		return nil, err
+	}
+	for _, checksum := range objects {
+		if markedObjects[checksum] {
+			continue
+		}
+		size, deleted, err := sweep(checksum, repository.GetObjectSize, repository.GetObjectRefCount, repository.DeleteObject, dryRun)
+		if err != nil {
+			return report, err
+		}
+		if !deleted {
+			report.InUse++
+			continue
+		}
+		logger.Trace("gc: object %s", checksum)
+		report.Objects++
+		report.ObjectsSize += size
+	}
+
+	chunks, err := repository.GetChunks()
+	if err != nil {
+		return nil, err
+	}
+	for _, checksum := range chunks {
+		if markedChunks[checksum] {
+			continue
+		}
+		size, deleted, err := sweep(checksum, repository.GetChunkSize, repository.GetChunkRefCount, repository.DeleteChunk, dryRun)
+		if err != nil {
+			return report, err
+		}
+		if !deleted {
+			report.InUse++
+			continue
+		}
+		logger.Trace("gc: chunk %s", checksum)
+		report.Chunks++
+		report.ChunksSize += size
+	}
+
+	return report, nil
+}
+
+func sweep(checksum string, getSize func(string) (uint64, error), getRefCount func(string) (uint64, error), remove func(string) (bool, error), dryRun bool) (uint64, bool, error) {
+	size, err := getSize(checksum)
+	if err != nil {
+		return 0, false, err
+	}
+	if dryRun {
+		// unmarked, any reference left comes from a transaction
+		refCount, err := getRefCount(checksum)
+		if err != nil {
+			return 0, false, err
+		}
+		return size, refCount == 0, nil
+	}
+	deleted, err := remove(checksum)
+	if err != nil {
+		return 0, false, err
+	}
+	return size, deleted, nil
+}
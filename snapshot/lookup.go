@@ -33,6 +33,20 @@ func (snapshot *Snapshot) LookupInodeForDirectory(pathname string) (*filesystem.
 	return snapshot.Index.Filesystem.LookupInodeForDirectory(pathname)
 }
 
+// LookupHardLink returns the first pathname of the hard link group of
+// pathname, if it is a link to a file recorded under another pathname.
+func (snapshot *Snapshot) LookupHardLink(pathname string) (string, bool) {
+	snapshot.Index.muHardLinks.Lock()
+	defer snapshot.Index.muHardLinks.Unlock()
+
+	first, exists := snapshot.Index.HardLinks[filepath.Clean(pathname)]
+	return first, exists
+}
+
+func (snapshot *Snapshot) LookupSymlink(pathname string) (string, bool) {
+	return snapshot.Index.Filesystem.LookupSymlink(pathname)
+}
+
 func (snapshot *Snapshot) LookupObjectForPathname(pathname string) *Object {
 	snapshot.Index.muPathnames.Lock()
 	defer snapshot.Index.muPathnames.Unlock()
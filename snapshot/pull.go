@@ -3,21 +3,208 @@ package snapshot
 import (
 	"crypto/sha256"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 
+	"github.com/poolpOrg/plakar/filesystem"
 	"github.com/poolpOrg/plakar/logger"
 )
 
-func (snapshot *Snapshot) Pull(root string, rebase bool, pattern string) {
+type PullOptions struct {
+	// restore extended attributes and POSIX ACLs recorded at push time
+	Xattrs bool
+	ACLs   bool
+
+	// restore owners by their recorded ids rather than by name
+	NumericOwner bool
+
+	// only list what would be written
+	DryRun bool
+
+	// keep restoring past pathnames that can't be restored
+	IgnoreErrors bool
+
+	// what to do with pathnames that already exist, one of the
+	// Conflict* policies, ConflictOverwrite if empty
+	OnConflict string
+
+	// number of files restored and chunks fetched concurrently,
+	// defaults to twice the number of CPUs if zero
+	Concurrency int
+}
+
+// number of chunks of a file fetched ahead of the one being written
+const pullPrefetch = 8
+
+// policies for pathnames that already exist at the restore location,
+// files whose content matches the snapshot are left untouched whatever
+// the policy
+const (
+	ConflictOverwrite = "overwrite"
+	ConflictSkip      = "skip"
+	ConflictIfNewer   = "if-newer"
+	ConflictRename    = "rename"
+)
+
+func ConflictPolicies() []string {
+	return []string{ConflictOverwrite, ConflictSkip, ConflictIfNewer, ConflictRename}
+}
+
+// upToDate reports whether dest is a regular file with the content of
+// the object identified by checksum.
+func upToDate(dest string, fi *filesystem.Fileinfo, checksum string) bool {
+	st, err := os.Lstat(dest)
+	if err != nil || !st.Mode().IsRegular() || st.Size() != fi.Size {
+		return false
+	}
+
+	fp, err := os.Open(dest)
+	if err != nil {
+		return false
+	}
+	defer fp.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, fp); err != nil {
+		return false
+	}
+	return checksum == fmt.Sprintf("%032x", hasher.Sum(nil))
+}
+
+// backupPathname returns the first free numbered backup name for dest.
+func backupPathname(dest string) string {
+	for i := 1; ; i++ {
+		backup := fmt.Sprintf("%s.~%d~", dest, i)
+		if _, err := os.Lstat(backup); os.IsNotExist(err) {
+			return backup
+		}
+	}
+}
+
+// resolveConflict applies the conflict policy to dest and returns false
+// if it must be left untouched. With ConflictRename, an existing dest is
+// moved aside to a numbered backup.
+func resolveConflict(dest string, fi *filesystem.Fileinfo, options *PullOptions) (bool, error) {
+	st, err := os.Lstat(dest)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch options.OnConflict {
+	case ConflictSkip:
+		return false, nil
+
+	case ConflictIfNewer:
+		return fi.ModTime.After(st.ModTime()), nil
+
+	case ConflictRename:
+		backup := backupPathname(dest)
+		if options.DryRun {
+			logger.Printf("rename %s => %s", dest, backup)
+			return true, nil
+		}
+		logger.Trace("rename %s => %s", dest, backup)
+		return true, os.Rename(dest, backup)
+
+	default:
+		return true, nil
+	}
+}
+
+func (snapshot *Snapshot) restoreAttributes(dest string, fi *filesystem.Fileinfo, options *PullOptions) {
+	if options.Xattrs {
+		if err := fi.RestoreXattrs(dest); err != nil {
+			logger.Warn("%s: %s", dest, err)
+		}
+	}
+	if options.ACLs {
+		if err := fi.RestoreACLs(dest); err != nil {
+			logger.Warn("%s: %s", dest, err)
+		}
+	}
+}
+
+func (snapshot *Snapshot) restoreOwner(dest string, fi *filesystem.Fileinfo, options *PullOptions) {
+	uid, gid := fi.Owner(options.NumericOwner)
+	os.Lchown(dest, uid, gid)
+}
+
+func (snapshot *Snapshot) restoreTimes(dest string, fi *filesystem.Fileinfo) {
+	if err := fi.RestoreTimes(dest); err != nil {
+		logger.Warn("%s: could not restore times: %s", dest, err)
+	}
+}
+
+// PullError describes a pathname that could not be restored.
+type PullError struct {
+	Pathname  string
+	Corrupted bool
+	Err       error
+}
+
+func (pullError PullError) Error() string {
+	return fmt.Sprintf("%s: %s", pullError.Pathname, pullError.Err)
+}
+
+// PullReport summarizes what a Pull restored and failed to restore.
+type PullReport struct {
+	Directories uint64
+	Files       uint64
+	Skipped     uint64
+	Failed      uint64
+	Corrupted   uint64
+	Size        uint64
+
+	muErrors sync.Mutex
+	Errors   []PullError
+}
+
+func (report *PullReport) failure(pathname string, corrupted bool, err error) {
+	if corrupted {
+		atomic.AddUint64(&report.Corrupted, 1)
+	} else {
+		atomic.AddUint64(&report.Failed, 1)
+	}
+	report.muErrors.Lock()
+	report.Errors = append(report.Errors, PullError{Pathname: pathname, Corrupted: corrupted, Err: err})
+	report.muErrors.Unlock()
+}
+
+func (report *PullReport) hasErrors() bool {
+	report.muErrors.Lock()
+	defer report.muErrors.Unlock()
+	return len(report.Errors) != 0
+}
+
+// Pull restores the pathnames of the snapshot matching pattern under
+// root. Unless options.IgnoreErrors is set, it stops at the first
+// pathname that can't be restored. The report is returned in all cases
+// and the error is set if any pathname failed.
+func (snapshot *Snapshot) Pull(root string, rebase bool, pattern string, options *PullOptions) (*PullReport, error) {
 	var wg sync.WaitGroup
-	maxDirectoriesConcurrency := make(chan bool, 1)
-	maxFilesConcurrency := make(chan bool, 1)
-	//maxChunksConcurrency := make(chan bool, 1024)
-	var dest string
+
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()*2 + 1
+	}
+	maxDirectoriesConcurrency := make(chan bool, concurrency)
+	maxFilesConcurrency := make(chan bool, concurrency)
+	maxChunksConcurrency := make(chan bool, concurrency)
+
+	report := &PullReport{Errors: make([]PullError, 0)}
+	aborted := func() bool {
+		return !options.IgnoreErrors && report.hasErrors()
+	}
 
 	dpattern := path.Clean(pattern)
 	fpattern := path.Clean(pattern)
@@ -38,12 +225,24 @@ func (snapshot *Snapshot) Pull(root string, rebase bool, pattern string) {
 		}
 	}
 
-	directoriesCount := 0
+	destination := func(filename string) string {
+		if rebase && strings.HasPrefix(filename, dpattern) {
+			return filepath.Clean(fmt.Sprintf("%s/%s", root, filename[len(dpattern):]))
+		}
+		return filepath.Clean(fmt.Sprintf("%s/%s", root, filename))
+	}
+
+	// attributes and times of directories are restored once their content
+	// is, so that default ACLs do not apply to the files created within
+	var muRestoredDirectories sync.Mutex
+	restoredDirectories := make(map[string]*filesystem.Fileinfo)
+
 	for _, directory := range snapshot.Index.Filesystem.ListDirectories() {
+		if aborted() {
+			break
+		}
 		if dpattern != "" {
-			if directory != dpattern &&
-				(!strings.HasPrefix(directory, fmt.Sprintf("%s/", dpattern)) ||
-					len(directory) > len(dpattern)) {
+			if directory != dpattern && !strings.HasPrefix(directory, fmt.Sprintf("%s/", dpattern)) {
 				continue
 			}
 		}
@@ -54,94 +253,339 @@ func (snapshot *Snapshot) Pull(root string, rebase bool, pattern string) {
 			defer func() { <-maxDirectoriesConcurrency }()
 			fi, _ := snapshot.LookupInodeForPathname(directory)
 			rel := path.Clean(fmt.Sprintf("./%s", directory))
-			if rebase && strings.HasPrefix(directory, dpattern) {
-				dest = fmt.Sprintf("%s/%s", root, directory[len(dpattern):])
-			} else {
-				dest = fmt.Sprintf("%s/%s", root, directory)
+			dest := destination(directory)
+
+			if options.DryRun {
+				logger.Printf("mkdir %s", dest)
+				return
 			}
 
 			logger.Trace("snapshot %s: mkdir %s, mode=%s, uid=%d, gid=%d", snapshot.Metadata.Uuid, rel, fi.Mode.String(), fi.Uid, fi.Gid)
-			os.MkdirAll(dest, 0700)
+			if err := os.MkdirAll(dest, 0700); err != nil {
+				report.failure(directory, false, err)
+				return
+			}
+			snapshot.restoreOwner(dest, fi, options)
 			os.Chmod(dest, fi.Mode)
-			os.Chown(dest, int(fi.Uid), int(fi.Gid))
-			directoriesCount++
+			muRestoredDirectories.Lock()
+			restoredDirectories[dest] = fi
+			muRestoredDirectories.Unlock()
+			atomic.AddUint64(&report.Directories, 1)
 		}(directory)
 	}
 	wg.Wait()
 
-	filesCount := 0
-	var filesSize uint64 = 0
+	matchFile := func(filename string) bool {
+		return fpattern == "" || filename == fpattern ||
+			strings.HasPrefix(filename, fmt.Sprintf("%s/", fpattern))
+	}
+
+	// hard links are created once the first pathname of their group is
+	// restored, unless it is not part of the pull and a copy is needed
+	hardLinks := make(map[string]string)
+
 	for _, filename := range snapshot.Index.Filesystem.ListFiles() {
-		if fpattern != "" {
-			if filename != fpattern &&
-				!strings.HasPrefix(filename, fmt.Sprintf("%s/", fpattern)) {
-				continue
-			}
+		if aborted() {
+			break
+		}
+		if !matchFile(filename) {
+			continue
+		}
+		if first, isLink := snapshot.LookupHardLink(filename); isLink && matchFile(first) {
+			hardLinks[filename] = first
+			continue
 		}
 		maxFilesConcurrency <- true
 		wg.Add(1)
 		go func(file string) {
 			defer wg.Done()
 			defer func() { <-maxFilesConcurrency }()
-			fi, _ := snapshot.LookupInodeForPathname(file)
-			rel := path.Clean(fmt.Sprintf("./%s", file))
-			if rebase && strings.HasPrefix(file, dpattern) {
-				dest = fmt.Sprintf("%s/%s", root, file[len(dpattern):])
-			} else {
-				dest = fmt.Sprintf("%s/%s", root, file)
-			}
-			dest = filepath.Clean(dest)
+			snapshot.pullFile(file, destination(file), options, report, maxChunksConcurrency)
+		}(filename)
+	}
+	wg.Wait()
 
-			checksum, exists := snapshot.Index.Pathnames[file]
-			if !exists {
-				logger.Warn("skipping %s", rel)
-				return
+	for filename, first := range hardLinks {
+		if aborted() {
+			break
+		}
+		dest := destination(filename)
+		if st, err := os.Lstat(dest); err == nil {
+			if firstSt, err := os.Lstat(destination(first)); err == nil && os.SameFile(st, firstSt) {
+				atomic.AddUint64(&report.Skipped, 1)
+				continue
 			}
+		}
+		fi, _ := snapshot.LookupInodeForPathname(filename)
+		proceed, err := resolveConflict(dest, fi, options)
+		if err != nil {
+			report.failure(filename, false, err)
+			continue
+		}
+		if !proceed {
+			atomic.AddUint64(&report.Skipped, 1)
+			continue
+		}
+		logger.Trace("snapshot %s: link %s to %s", snapshot.Metadata.Uuid, path.Clean(fmt.Sprintf("./%s", filename)), path.Clean(fmt.Sprintf("./%s", first)))
+		if options.DryRun {
+			logger.Printf("link %s => %s", dest, destination(first))
+			continue
+		}
+		os.Remove(dest)
+		if err := os.Link(destination(first), dest); err != nil {
+			report.failure(filename, false, err)
+			continue
+		}
+		atomic.AddUint64(&report.Files, 1)
+	}
 
-			logger.Trace("snapshot %s: create %s, mode=%s, uid=%d, gid=%d", snapshot.Metadata.Uuid, rel, fi.Mode.String(), fi.Uid, fi.Gid)
+	for _, filename := range snapshot.Index.Filesystem.ListNonRegular() {
+		if aborted() {
+			break
+		}
+		if !matchFile(filename) {
+			continue
+		}
+		fi, _ := snapshot.LookupInodeForPathname(filename)
+		dest := destination(filename)
+		rel := path.Clean(fmt.Sprintf("./%s", filename))
 
-			f, err := os.Create(dest)
-			if err != nil {
-				return
+		if fi.Mode&os.ModeSymlink != 0 {
+			target, _ := snapshot.LookupSymlink(filename)
+			if current, err := os.Readlink(dest); err == nil && current == target {
+				atomic.AddUint64(&report.Skipped, 1)
+				continue
 			}
-			defer f.Close()
+		}
+		proceed, err := resolveConflict(dest, fi, options)
+		if err != nil {
+			report.failure(filename, false, err)
+			continue
+		}
+		if !proceed {
+			atomic.AddUint64(&report.Skipped, 1)
+			continue
+		}
 
-			object, err := snapshot.GetObject(checksum)
-			if err != nil {
-				return
+		switch {
+		case fi.Mode&os.ModeSymlink != 0:
+			target, exists := snapshot.LookupSymlink(filename)
+			if !exists {
+				report.failure(filename, true, fmt.Errorf("unknown symlink target"))
+				continue
 			}
-
-			objectHash := sha256.New()
-			for _, chunkChecksum := range object.Chunks {
-				data, err := snapshot.GetChunk(chunkChecksum)
-				if err != nil {
-					continue
-				}
-
-				chunk, _ := snapshot.GetChunkInfo(chunkChecksum)
-
-				if len(data) != int(chunk.Length) {
-					continue
-				} else {
-					chunkHash := sha256.New()
-					chunkHash.Write(data)
-					if chunk.Checksum != fmt.Sprintf("%032x", chunkHash.Sum(nil)) {
-						continue
-					}
-				}
-				objectHash.Write(data)
-				f.Write(data)
-				filesSize += uint64(len(data))
+			if options.DryRun {
+				logger.Printf("symlink %s -> %s", dest, target)
+				continue
 			}
-			if checksum != fmt.Sprintf("%032x", objectHash.Sum(nil)) {
+			logger.Trace("snapshot %s: symlink %s -> %s", snapshot.Metadata.Uuid, rel, target)
+			os.Remove(dest)
+			if err := os.Symlink(target, dest); err != nil {
+				report.failure(filename, false, err)
+				continue
 			}
+			snapshot.restoreOwner(dest, fi, options)
+			snapshot.restoreTimes(dest, fi)
 
-			f.Sync()
-			f.Close()
+		case fi.Mode&os.ModeNamedPipe != 0 || fi.IsDevice():
+			if fi.IsDevice() && os.Geteuid() != 0 {
+				logger.Warn("skipping %s: restoring device nodes requires root privileges", rel)
+				atomic.AddUint64(&report.Skipped, 1)
+				continue
+			}
+			if options.DryRun {
+				logger.Printf("mknod %s", dest)
+				continue
+			}
+			logger.Trace("snapshot %s: mknod %s, mode=%s, uid=%d, gid=%d", snapshot.Metadata.Uuid, rel, fi.Mode.String(), fi.Uid, fi.Gid)
+			os.Remove(dest)
+			if err := fi.Mknod(dest); err != nil {
+				report.failure(filename, false, err)
+				continue
+			}
+			snapshot.restoreOwner(dest, fi, options)
 			os.Chmod(dest, fi.Mode)
-			os.Chown(dest, int(fi.Uid), int(fi.Gid))
-			filesCount++
-		}(filename)
+			snapshot.restoreAttributes(dest, fi, options)
+			snapshot.restoreTimes(dest, fi)
+
+		default:
+			// sockets are recreated by the programs listening on them
+			logger.Trace("snapshot %s: skipping %s, mode=%s", snapshot.Metadata.Uuid, rel, fi.Mode.String())
+			atomic.AddUint64(&report.Skipped, 1)
+			continue
+		}
+		atomic.AddUint64(&report.Files, 1)
 	}
-	wg.Wait()
+
+	// creating entries updates the modification time of directories, so
+	// theirs are restored last
+	for dest, fi := range restoredDirectories {
+		snapshot.restoreAttributes(dest, fi, options)
+		snapshot.restoreTimes(dest, fi)
+	}
+
+	if len(report.Errors) != 0 {
+		if !options.IgnoreErrors {
+			return report, report.Errors[0]
+		}
+		return report, fmt.Errorf("%d pathnames could not be restored", len(report.Errors))
+	}
+	return report, nil
+}
+
+// pullFile restores the regular file pathname to dest.
+func (snapshot *Snapshot) pullFile(pathname string, dest string, options *PullOptions, report *PullReport, maxChunksConcurrency chan bool) {
+	fi, _ := snapshot.LookupInodeForPathname(pathname)
+	rel := path.Clean(fmt.Sprintf("./%s", pathname))
+
+	checksum, exists := snapshot.Index.Pathnames[pathname]
+	if !exists {
+		report.failure(pathname, true, fmt.Errorf("no object in snapshot"))
+		return
+	}
+
+	if upToDate(dest, fi, checksum) {
+		logger.Trace("snapshot %s: %s is up to date", snapshot.Metadata.Uuid, rel)
+		atomic.AddUint64(&report.Skipped, 1)
+		if options.DryRun {
+			return
+		}
+		snapshot.restoreOwner(dest, fi, options)
+		os.Chmod(dest, fi.Mode)
+		snapshot.restoreAttributes(dest, fi, options)
+		snapshot.restoreTimes(dest, fi)
+		return
+	}
+
+	proceed, err := resolveConflict(dest, fi, options)
+	if err != nil {
+		report.failure(pathname, false, err)
+		return
+	}
+	if !proceed {
+		logger.Trace("snapshot %s: %s exists, skipping", snapshot.Metadata.Uuid, rel)
+		atomic.AddUint64(&report.Skipped, 1)
+		return
+	}
+
+	if options.DryRun {
+		logger.Printf("create %s", dest)
+		return
+	}
+
+	logger.Trace("snapshot %s: create %s, mode=%s, uid=%d, gid=%d", snapshot.Metadata.Uuid, rel, fi.Mode.String(), fi.Uid, fi.Gid)
+
+	object, err := snapshot.GetObject(checksum)
+	if err != nil {
+		report.failure(pathname, false, err)
+		return
+	}
+
+	// content is written to a temporary file renamed into place once
+	// complete, so that an existing file is never replaced by a partial
+	// or corrupted restore
+	f, err := ioutil.TempFile(filepath.Dir(dest), fmt.Sprintf(".%s.plakar-", filepath.Base(dest)))
+	if err != nil {
+		report.failure(pathname, false, err)
+		return
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	chunks, stop := snapshot.prefetchChunks(object.Chunks, maxChunksConcurrency)
+	defer close(stop)
+
+	var offset int64
+	objectHash := sha256.New()
+	for _, chunkChecksum := range object.Chunks {
+		fetched := <-<-chunks
+		data, err := fetched.data, fetched.err
+		if err != nil {
+			report.failure(pathname, false, fmt.Errorf("chunk %s: %s", chunkChecksum, err))
+			return
+		}
+
+		chunk, exists := snapshot.GetChunkInfo(chunkChecksum)
+		if !exists {
+			report.failure(pathname, true, fmt.Errorf("chunk %s: not in index", chunkChecksum))
+			return
+		}
+		if len(data) != int(chunk.Length) {
+			report.failure(pathname, true, fmt.Errorf("chunk %s: invalid length", chunkChecksum))
+			return
+		}
+		chunkHash := sha256.New()
+		chunkHash.Write(data)
+		if chunk.Checksum != fmt.Sprintf("%032x", chunkHash.Sum(nil)) {
+			report.failure(pathname, true, fmt.Errorf("chunk %s: checksum mismatch", chunkChecksum))
+			return
+		}
+
+		objectHash.Write(data)
+		if err := filesystem.WriteSparse(f, data, offset, object.Holes); err != nil {
+			report.failure(pathname, false, err)
+			return
+		}
+		offset += int64(len(data))
+	}
+	if len(object.Holes) != 0 {
+		// a trailing hole is only accounted for in the size
+		f.Truncate(offset)
+	}
+	if checksum != fmt.Sprintf("%032x", objectHash.Sum(nil)) {
+		report.failure(pathname, true, fmt.Errorf("object checksum mismatch"))
+		return
+	}
+
+	if err := f.Sync(); err != nil {
+		report.failure(pathname, false, err)
+		return
+	}
+	f.Close()
+	snapshot.restoreOwner(tmp, fi, options)
+	os.Chmod(tmp, fi.Mode)
+	snapshot.restoreAttributes(tmp, fi, options)
+	if err := os.Rename(tmp, dest); err != nil {
+		report.failure(pathname, false, err)
+		return
+	}
+	snapshot.restoreTimes(dest, fi)
+	atomic.AddUint64(&report.Files, 1)
+	atomic.AddUint64(&report.Size, uint64(offset))
+}
+
+type prefetchedChunk struct {
+	data []byte
+	err  error
+}
+
+// prefetchChunks fetches chunks concurrently, at most pullPrefetch ahead
+// of the consumer, and queues their results in order. Closing the stop
+// channel releases the goroutines if the consumer gives up early.
+func (snapshot *Snapshot) prefetchChunks(checksums []string, maxChunksConcurrency chan bool) (<-chan chan prefetchedChunk, chan bool) {
+	queue := make(chan chan prefetchedChunk, pullPrefetch)
+	stop := make(chan bool)
+
+	go func() {
+		for _, checksum := range checksums {
+			result := make(chan prefetchedChunk, 1)
+			select {
+			case queue <- result:
+			case <-stop:
+				return
+			}
+			select {
+			case maxChunksConcurrency <- true:
+			case <-stop:
+				return
+			}
+			go func(checksum string) {
+				defer func() { <-maxChunksConcurrency }()
+				data, err := snapshot.GetChunk(checksum)
+				result <- prefetchedChunk{data: data, err: err}
+			}(checksum)
+		}
+	}()
+	return queue, stop
 }
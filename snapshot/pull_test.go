@@ -0,0 +1,99 @@
+package snapshot
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/poolpOrg/plakar/logger"
+	"github.com/poolpOrg/plakar/storage"
+	_ "github.com/poolpOrg/plakar/storage/fs"
+)
+
+func TestPullSubtree(t *testing.T) {
+	stop := logger.Start()
+	defer stop()
+
+	tmp, err := ioutil.TempDir("", "plakar-pull-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	source := filepath.Join(tmp, "source")
+	files := map[string]string{
+		"a/x.txt":        "x",
+		"a/b/f.txt":      "f",
+		"a/b/c/g.txt":    "g",
+		"a/b/c/d/h.txt":  "h",
+		"a/bb/other.txt": "other",
+	}
+	if err := os.MkdirAll(filepath.Join(source, "a/b/c/empty"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		pathname := filepath.Join(source, name)
+		if err := os.MkdirAll(filepath.Dir(pathname), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(pathname, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repository, err := storage.Create(filepath.Join(tmp, "repository"), storage.RepositoryConfig{Uuid: uuid.NewString()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repository.Close()
+
+	snap, err := New(repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snap.Push(context.Background(), []string{source}); err != nil {
+		t.Fatal(err)
+	}
+	snap, err = Load(repository, snap.Metadata.Uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// directories below the pulled one must be created for their files
+	// to be restored
+	restored := filepath.Join(tmp, "restored")
+	report, err := snap.Pull(restored, true, filepath.Join(source, "a/b"), &PullOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Errors) != 0 {
+		t.Fatalf("Pull() failed: %v", report.Errors)
+	}
+
+	expected := map[string]string{
+		"f.txt":     "f",
+		"c/g.txt":   "g",
+		"c/d/h.txt": "h",
+	}
+	for name, content := range expected {
+		data, err := ioutil.ReadFile(filepath.Join(restored, name))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("%s: restored %q, expected %q", name, data, content)
+		}
+	}
+	if st, err := os.Stat(filepath.Join(restored, "c/empty")); err != nil || !st.IsDir() {
+		t.Errorf("empty directory c/empty was not restored")
+	}
+	for _, name := range []string{"x.txt", "bb", "../bb"} {
+		if _, err := os.Stat(filepath.Join(restored, name)); err == nil {
+			t.Errorf("%s is outside of the pulled subtree but was restored", name)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package snapshot
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -17,6 +19,7 @@ import (
 
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/poolpOrg/go-fastcdc"
+	"github.com/poolpOrg/plakar/compression"
 	"github.com/poolpOrg/plakar/filesystem"
 	"github.com/poolpOrg/plakar/logger"
 )
@@ -186,6 +189,7 @@ func pathnameCached(snapshot *Snapshot, fi filesystem.Fileinfo, pathname string)
 		object.Chunks = append(object.Chunks, chunk.Checksum)
 	}
 	object.ContentType = cachedObject.ContentType
+	object.Holes = cachedObject.Holes
 
 	for offset, chunkChecksum := range object.Chunks {
 		snapshot.Index.muChunks.Lock()
@@ -207,6 +211,13 @@ func chunkify(chunkerOptions *fastcdc.ChunkerOpts, snapshot *Snapshot, pathname
 	object := &Object{}
 	objectHash := sha256.New()
 
+	if st, err := rd.Stat(); err == nil {
+		object.Holes, err = filesystem.ReadHoles(rd, st.Size())
+		if err != nil {
+			logger.Warn("%s: could not detect holes: %s", pathname, err)
+		}
+	}
+
 	chk, err := fastcdc.NewChunker(rd, chunkerOptions)
 	if err != nil {
 		logger.Warn("%s", err)
@@ -214,6 +225,7 @@ func chunkify(chunkerOptions *fastcdc.ChunkerOpts, snapshot *Snapshot, pathname
 	}
 
 	firstChunk := true
+	compressible := true
 	for {
 		cdcChunk, err := chk.Next()
 		if err == io.EOF {
@@ -228,6 +240,7 @@ func chunkify(chunkerOptions *fastcdc.ChunkerOpts, snapshot *Snapshot, pathname
 			if object.ContentType == "" {
 				object.ContentType = mimetype.Detect(cdcChunk.Data).String()
 			}
+			compressible = !compression.IsCompressedContentType(object.ContentType)
 			firstChunk = false
 		}
 
@@ -256,7 +269,7 @@ func chunkify(chunkerOptions *fastcdc.ChunkerOpts, snapshot *Snapshot, pathname
 		if !res[0] {
 			snapshot.Index.muChunks.Lock()
 			if _, ok := snapshot.Index.Chunks[chunk.Checksum]; !ok {
-				err = snapshot.PutChunk(chunk.Checksum, cdcChunk.Data)
+				err = snapshot.PutChunk(chunk.Checksum, cdcChunk.Data, compressible && compression.IsCompressible(cdcChunk.Data))
 				if err == nil {
 					snapshot.Index.Chunks[chunk.Checksum] = &chunk
 				}
@@ -275,7 +288,10 @@ func chunkify(chunkerOptions *fastcdc.ChunkerOpts, snapshot *Snapshot, pathname
 	return object, nil
 }
 
-func (snapshot *Snapshot) Push(scanDirs []string) error {
+// Push scans scanDirs and stores the files they contain in the snapshot,
+// which is committed once done. If ctx is cancelled, the push stops and
+// is either checkpointed so that it can be resumed or rolled back.
+func (snapshot *Snapshot) Push(ctx context.Context, scanDirs []string) error {
 	t0 := time.Now()
 
 	cache := snapshot.repository.Cache
@@ -285,7 +301,25 @@ func (snapshot *Snapshot) Push(scanDirs []string) error {
 		if err != nil {
 			return err
 		}
-		err = snapshot.Index.Filesystem.Scan(scanDir, snapshot.SkipDirs)
+		err = snapshot.Index.Filesystem.Scan(scanDir, &filesystem.ScanOptions{
+			SkipDirs:      snapshot.SkipDirs,
+			Excludes:      snapshot.Excludes,
+			OneFileSystem: snapshot.OneFileSystem,
+			SkipModes:     snapshot.SkipModes,
+			MaxSize:       snapshot.MaxSize,
+			Xattrs:        snapshot.Xattrs,
+			ACLs:          snapshot.ACLs,
+			Context:       ctx,
+		})
+		if ctx.Err() != nil {
+			// nothing was stored since the last checkpoint, if any
+			if snapshot.checkpoint == nil {
+				if err := snapshot.Rollback(); err != nil {
+					logger.Warn("could not roll back: %s", err)
+				}
+			}
+			return ctx.Err()
+		}
 		if err != nil {
 			//errchan<-err
 		}
@@ -295,9 +329,43 @@ func (snapshot *Snapshot) Push(scanDirs []string) error {
 
 	chunkerOptions := fastcdc.NewChunkerOptions()
 
+	// files sharing an inode are hard links, only the first pathname of
+	// each group is chunkified and the others refer to its object
+	files := snapshot.Index.Filesystem.ListFiles()
+	sort.Strings(files)
+	inodes := make(map[[2]uint64]string)
+	for _, pathname := range files {
+		fileinfo, _ := snapshot.Index.Filesystem.LookupInodeForFile(pathname)
+		inode := [2]uint64{fileinfo.Dev, fileinfo.Ino}
+		if first, exists := inodes[inode]; exists {
+			snapshot.Index.HardLinks[pathname] = first
+		} else {
+			inodes[inode] = pathname
+		}
+	}
+
+	snapshot.Metadata.ScannedDirectories = snapshot.Index.Filesystem.ScannedDirectories
+
 	maxConcurrency := make(chan bool, runtime.NumCPU()*2+1)
 	wg := sync.WaitGroup{}
-	for _, pathname := range snapshot.Index.Filesystem.ListFiles() {
+	lastCheckpoint := time.Now()
+	for _, pathname := range files {
+		if _, isLink := snapshot.Index.HardLinks[pathname]; isLink {
+			continue
+		}
+		if ctx.Err() != nil {
+			wg.Wait()
+			chanObjectsProcessorDone()
+			return snapshot.interrupt(ctx)
+		}
+		if snapshot.CheckpointInterval != 0 && time.Since(lastCheckpoint) >= snapshot.CheckpointInterval {
+			// the index can't be serialized while files are processed
+			wg.Wait()
+			if err := snapshot.Checkpoint(); err != nil {
+				logger.Warn("could not checkpoint: %s", err)
+			}
+			lastCheckpoint = time.Now()
+		}
 		fileinfo, _ := snapshot.Index.Filesystem.LookupInodeForFile(pathname)
 		maxConcurrency <- true
 		wg.Add(1)
@@ -305,17 +373,20 @@ func (snapshot *Snapshot) Push(scanDirs []string) error {
 			defer wg.Done()
 			defer func() { <-maxConcurrency }()
 
-			var object *Object
-
-			// XXX - later optim: if fileinfo.Dev && fileinfo.Ino already exist in this snapshot
-			// lookup object from snapshot and bypass scanning
-
-			object, err := pathnameCached(snapshot, *fileinfo, pathname)
+			object, err := pathnameCheckpointed(snapshot, *fileinfo, pathname)
 			if err != nil {
-				// something went wrong with the cache
+				// something went wrong with the checkpoint
 				// errchan <- err
 			}
 
+			if object == nil {
+				object, err = pathnameCached(snapshot, *fileinfo, pathname)
+				if err != nil {
+					// something went wrong with the cache
+					// errchan <- err
+				}
+			}
+
 			// can't reuse object from cache, chunkify
 			if object == nil {
 				object, err = chunkify(chunkerOptions, snapshot, pathname)
@@ -351,6 +422,17 @@ func (snapshot *Snapshot) Push(scanDirs []string) error {
 	}
 	wg.Wait()
 
+	for pathname, first := range snapshot.Index.HardLinks {
+		checksum, exists := snapshot.Index.Pathnames[first]
+		if !exists {
+			// first pathname could not be pushed
+			delete(snapshot.Index.HardLinks, pathname)
+			continue
+		}
+		snapshot.Index.Pathnames[pathname] = checksum
+		snapshot.Index.ObjectToPathnames[checksum] = append(snapshot.Index.ObjectToPathnames[checksum], pathname)
+	}
+
 	chanObjectsProcessor <- snapshot.Index.Objects
 	chanObjectsProcessorDone()
 
@@ -399,11 +481,35 @@ func (snapshot *Snapshot) Push(scanDirs []string) error {
 		snapshot.Metadata.Statistics.PercentExtension[key] = math.Round((float64(value)/float64(snapshot.Metadata.Statistics.Files)*100)*100) / 100
 	}
 
-	snapshot.Metadata.ScannedDirectories = snapshot.Index.Filesystem.ScannedDirectories
 	snapshot.Metadata.Statistics.NonRegular = uint64(len(snapshot.Index.Filesystem.NonRegular))
 	snapshot.Metadata.Statistics.Pathnames = uint64(len(snapshot.Index.Pathnames))
+	snapshot.Metadata.Statistics.Excluded = snapshot.Index.Filesystem.ExcludedCount()
+	snapshot.Metadata.Skipped = snapshot.Index.Filesystem.ListSkipped()
+	snapshot.Metadata.Statistics.Skipped = uint64(len(snapshot.Metadata.Skipped))
+	snapshot.Metadata.Statistics.HardLinks = uint64(len(snapshot.Index.HardLinks))
 
 	snapshot.Metadata.Statistics.Duration = time.Since(t0)
 
 	return snapshot.Commit()
 }
+
+// interrupt stops a push whose context was cancelled once files in
+// progress are stored, it is checkpointed if checkpoints are enabled and
+// rolled back otherwise, unless it resumed an earlier checkpoint.
+func (snapshot *Snapshot) interrupt(ctx context.Context) error {
+	if snapshot.CheckpointInterval != 0 {
+		err := snapshot.Checkpoint()
+		if err == nil {
+			return ctx.Err()
+		}
+		logger.Warn("could not checkpoint: %s", err)
+	}
+	if snapshot.checkpoint != nil {
+		// resumed, its last checkpoint is still valid
+		return ctx.Err()
+	}
+	if err := snapshot.Rollback(); err != nil {
+		logger.Warn("could not roll back: %s", err)
+	}
+	return ctx.Err()
+}
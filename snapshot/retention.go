@@ -0,0 +1,138 @@
+package snapshot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy describes which snapshots of a group are kept, following
+// a grandfather-father-son scheme: the most recent snapshot of each of the
+// last N hours, days, weeks, months and years is kept, along with the last
+// snapshots and those taken within a duration of the latest one.
+type RetentionPolicy struct {
+	Last    int
+	Hourly  int
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
+	Within  time.Duration
+
+	// snapshots carrying any of these tags are always kept
+	Tags []string
+}
+
+type RetentionDecision struct {
+	Metadata *Metadata
+
+	// why the snapshot is kept, it is forgotten if there's no reason to
+	// keep it
+	Reasons []string
+}
+
+func (policy RetentionPolicy) IsEmpty() bool {
+	return policy.Last == 0 && policy.Hourly == 0 && policy.Daily == 0 &&
+		policy.Weekly == 0 && policy.Monthly == 0 && policy.Yearly == 0 &&
+		policy.Within == 0 && len(policy.Tags) == 0
+}
+
+// Apply decides which snapshots of a group are kept, decisions are returned
+// from the most recent snapshot to the oldest.
+func (policy RetentionPolicy) Apply(metadatas []*Metadata) []RetentionDecision {
+	decisions := make([]RetentionDecision, 0, len(metadatas))
+	for _, metadata := range metadatas {
+		decisions = append(decisions, RetentionDecision{Metadata: metadata, Reasons: make([]string, 0)})
+	}
+	sort.Slice(decisions, func(i, j int) bool {
+		return decisions[i].Metadata.CreationTime.After(decisions[j].Metadata.CreationTime)
+	})
+	if len(decisions) == 0 {
+		return decisions
+	}
+
+	// relative to the latest snapshot rather than now, a host that stopped
+	// pushing doesn't lose its history
+	latest := decisions[0].Metadata.CreationTime
+	for i := range decisions {
+		if i < policy.Last {
+			decisions[i].Reasons = append(decisions[i].Reasons, fmt.Sprintf("last %d", policy.Last))
+		}
+		if policy.Within != 0 && latest.Sub(decisions[i].Metadata.CreationTime) <= policy.Within {
+			decisions[i].Reasons = append(decisions[i].Reasons, fmt.Sprintf("within %s", formatRetentionDuration(policy.Within)))
+		}
+		for _, tag := range policy.Tags {
+			if decisions[i].Metadata.HasTag(tag) {
+				decisions[i].Reasons = append(decisions[i].Reasons, fmt.Sprintf("tagged %s", tag))
+			}
+		}
+	}
+
+	buckets := []struct {
+		name   string
+		count  int
+		period func(time.Time) string
+	}{
+		{"hourly", policy.Hourly, func(t time.Time) string { return t.Format("2006-01-02 15h") }},
+		{"daily", policy.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", policy.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", policy.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", policy.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+	for _, bucket := range buckets {
+		// the most recent snapshot of a period is the first one seen
+		last := ""
+		kept := 0
+		for i := range decisions {
+			if kept == bucket.count {
+				break
+			}
+			period := bucket.period(decisions[i].Metadata.CreationTime)
+			if period == last {
+				continue
+			}
+			last = period
+			kept++
+			decisions[i].Reasons = append(decisions[i].Reasons, fmt.Sprintf("%s %s", bucket.name, period))
+		}
+	}
+
+	return decisions
+}
+
+// RetentionGroup returns the group a snapshot belongs to when a policy is
+// applied to snapshots grouped by host, paths and/or tags.
+func RetentionGroup(metadata *Metadata, groupBy []string) string {
+	if len(groupBy) == 0 {
+		return "all snapshots"
+	}
+
+	key := make([]string, 0, len(groupBy))
+	for _, field := range groupBy {
+		switch field {
+		case "host":
+			key = append(key, fmt.Sprintf("host %s", metadata.Hostname))
+		case "paths":
+			paths := append([]string{}, metadata.ScannedDirectories...)
+			sort.Strings(paths)
+			key = append(key, fmt.Sprintf("paths %s", strings.Join(paths, ",")))
+		case "tags":
+			tags := append([]string{}, metadata.Tags...)
+			sort.Strings(tags)
+			key = append(key, fmt.Sprintf("tags %s", strings.Join(tags, ",")))
+		}
+	}
+	return strings.Join(key, ", ")
+}
+
+func formatRetentionDuration(duration time.Duration) string {
+	day := 24 * time.Hour
+	if duration%day == 0 {
+		return fmt.Sprintf("%dd", duration/day)
+	}
+	return duration.String()
+}
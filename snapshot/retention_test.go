@@ -0,0 +1,248 @@
+package snapshot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type retentionSnapshot struct {
+	uuid  string
+	host  string
+	paths []string
+	tags  []string
+	time  string
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    RetentionPolicy
+		groupBy   []string
+		snapshots []retentionSnapshot
+		// reasons of the kept snapshots, the others are forgotten
+		kept map[string][]string
+	}{
+		{
+			name:   "last",
+			policy: RetentionPolicy{Last: 2},
+			snapshots: []retentionSnapshot{
+				{uuid: "a", time: "2021-06-13T10:00:00Z"},
+				{uuid: "b", time: "2021-06-15T10:00:00Z"},
+				{uuid: "c", time: "2021-06-14T10:00:00Z"},
+			},
+			kept: map[string][]string{
+				"b": {"last 2"},
+				"c": {"last 2"},
+			},
+		},
+		{
+			name:   "hourly keeps the latest snapshot of each hour",
+			policy: RetentionPolicy{Hourly: 2},
+			snapshots: []retentionSnapshot{
+				{uuid: "a", time: "2021-06-15T12:45:00Z"},
+				{uuid: "b", time: "2021-06-15T12:15:00Z"},
+				{uuid: "c", time: "2021-06-15T10:59:00Z"},
+				{uuid: "d", time: "2021-06-15T09:30:00Z"},
+			},
+			kept: map[string][]string{
+				"a": {"hourly 2021-06-15 12h"},
+				"c": {"hourly 2021-06-15 10h"},
+			},
+		},
+		{
+			name:   "daily keeps the latest snapshot of each day",
+			policy: RetentionPolicy{Daily: 3},
+			snapshots: []retentionSnapshot{
+				{uuid: "a", time: "2021-06-15T12:00:00Z"},
+				{uuid: "b", time: "2021-06-15T08:00:00Z"},
+				{uuid: "c", time: "2021-06-14T20:00:00Z"},
+				{uuid: "d", time: "2021-06-13T10:00:00Z"},
+				{uuid: "e", time: "2021-06-12T10:00:00Z"},
+			},
+			kept: map[string][]string{
+				"a": {"daily 2021-06-15"},
+				"c": {"daily 2021-06-14"},
+				"d": {"daily 2021-06-13"},
+			},
+		},
+		{
+			name:   "weekly and monthly buckets overlap",
+			policy: RetentionPolicy{Weekly: 2, Monthly: 2},
+			snapshots: []retentionSnapshot{
+				{uuid: "a", time: "2021-06-15T10:00:00Z"},
+				{uuid: "b", time: "2021-06-14T10:00:00Z"},
+				{uuid: "c", time: "2021-06-09T10:00:00Z"},
+				{uuid: "d", time: "2021-05-31T10:00:00Z"},
+				{uuid: "e", time: "2021-04-10T10:00:00Z"},
+			},
+			kept: map[string][]string{
+				"a": {"weekly 2021-W24", "monthly 2021-06"},
+				"c": {"weekly 2021-W23"},
+				"d": {"monthly 2021-05"},
+			},
+		},
+		{
+			name:   "weeks follow ISO years",
+			policy: RetentionPolicy{Weekly: 2, Yearly: 2},
+			snapshots: []retentionSnapshot{
+				{uuid: "a", time: "2021-01-02T10:00:00Z"},
+				{uuid: "b", time: "2020-12-30T10:00:00Z"},
+				{uuid: "c", time: "2020-12-27T10:00:00Z"},
+			},
+			kept: map[string][]string{
+				"a": {"weekly 2020-W53", "yearly 2021"},
+				"b": {"yearly 2020"},
+				"c": {"weekly 2020-W52"},
+			},
+		},
+		{
+			name:   "within is relative to the latest snapshot and inclusive",
+			policy: RetentionPolicy{Within: 48 * time.Hour},
+			snapshots: []retentionSnapshot{
+				{uuid: "a", time: "2021-06-15T12:00:00Z"},
+				{uuid: "b", time: "2021-06-13T12:00:00Z"},
+				{uuid: "c", time: "2021-06-13T11:59:59Z"},
+			},
+			kept: map[string][]string{
+				"a": {"within 2d"},
+				"b": {"within 2d"},
+			},
+		},
+		{
+			name:   "tagged",
+			policy: RetentionPolicy{Last: 1, Tags: []string{"release"}},
+			snapshots: []retentionSnapshot{
+				{uuid: "a", time: "2021-06-15T12:00:00Z"},
+				{uuid: "b", time: "2021-06-14T12:00:00Z", tags: []string{"release=1.0"}},
+				{uuid: "c", time: "2021-06-13T12:00:00Z", tags: []string{"nightly"}},
+			},
+			kept: map[string][]string{
+				"a": {"last 1"},
+				"b": {"tagged release"},
+			},
+		},
+		{
+			name:    "grouped by host",
+			policy:  RetentionPolicy{Last: 1},
+			groupBy: []string{"host"},
+			snapshots: []retentionSnapshot{
+				{uuid: "a", host: "alpha", paths: []string{"/etc"}, time: "2021-06-15T12:00:00Z"},
+				{uuid: "b", host: "alpha", paths: []string{"/home"}, time: "2021-06-14T12:00:00Z"},
+				{uuid: "c", host: "beta", paths: []string{"/etc"}, time: "2021-06-01T12:00:00Z"},
+				{uuid: "d", host: "beta", paths: []string{"/etc"}, time: "2021-05-01T12:00:00Z"},
+			},
+			kept: map[string][]string{
+				"a": {"last 1"},
+				"c": {"last 1"},
+			},
+		},
+		{
+			name:    "grouped by paths",
+			policy:  RetentionPolicy{Last: 1},
+			groupBy: []string{"paths"},
+			snapshots: []retentionSnapshot{
+				{uuid: "a", host: "alpha", paths: []string{"/etc", "/home"}, time: "2021-06-15T12:00:00Z"},
+				{uuid: "b", host: "beta", paths: []string{"/home", "/etc"}, time: "2021-06-14T12:00:00Z"},
+				{uuid: "c", host: "alpha", paths: []string{"/etc"}, time: "2021-06-13T12:00:00Z"},
+			},
+			kept: map[string][]string{
+				"a": {"last 1"},
+				"c": {"last 1"},
+			},
+		},
+		{
+			name:    "grouped by host and paths",
+			policy:  RetentionPolicy{Last: 1},
+			groupBy: []string{"host", "paths"},
+			snapshots: []retentionSnapshot{
+				{uuid: "a", host: "alpha", paths: []string{"/etc"}, time: "2021-06-15T12:00:00Z"},
+				{uuid: "b", host: "alpha", paths: []string{"/etc"}, time: "2021-06-14T12:00:00Z"},
+				{uuid: "c", host: "beta", paths: []string{"/etc"}, time: "2021-06-13T12:00:00Z"},
+				{uuid: "d", host: "alpha", paths: []string{"/home"}, time: "2021-06-12T12:00:00Z"},
+			},
+			kept: map[string][]string{
+				"a": {"last 1"},
+				"c": {"last 1"},
+				"d": {"last 1"},
+			},
+		},
+		{
+			name:   "ungrouped",
+			policy: RetentionPolicy{Last: 1},
+			snapshots: []retentionSnapshot{
+				{uuid: "a", host: "alpha", time: "2021-06-14T12:00:00Z"},
+				{uuid: "b", host: "beta", time: "2021-06-15T12:00:00Z"},
+			},
+			kept: map[string][]string{
+				"b": {"last 1"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		groups := make(map[string][]*Metadata)
+		for _, snapshot := range test.snapshots {
+			creationTime, err := time.Parse(time.RFC3339, snapshot.time)
+			if err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+			metadata := &Metadata{
+				Uuid:               snapshot.uuid,
+				CreationTime:       creationTime,
+				Hostname:           snapshot.host,
+				ScannedDirectories: snapshot.paths,
+				Tags:               snapshot.tags,
+			}
+			key := RetentionGroup(metadata, test.groupBy)
+			groups[key] = append(groups[key], metadata)
+		}
+
+		kept := make(map[string][]string)
+		for _, metadatas := range groups {
+			decisions := test.policy.Apply(metadatas)
+			for i, decision := range decisions {
+				if i > 0 && decision.Metadata.CreationTime.After(decisions[i-1].Metadata.CreationTime) {
+					t.Errorf("%s: decisions are not sorted from the most recent snapshot", test.name)
+				}
+				if len(decision.Reasons) != 0 {
+					kept[decision.Metadata.Uuid] = decision.Reasons
+				}
+			}
+		}
+		if !reflect.DeepEqual(kept, test.kept) {
+			t.Errorf("%s: kept %v, expected %v", test.name, kept, test.kept)
+		}
+	}
+}
+
+func TestRetentionGroup(t *testing.T) {
+	metadata := &Metadata{
+		Hostname:           "alpha",
+		ScannedDirectories: []string{"/home", "/etc"},
+		Tags:               []string{"nightly", "env=prod"},
+	}
+
+	tests := []struct {
+		groupBy []string
+		key     string
+	}{
+		{nil, "all snapshots"},
+		{[]string{"host"}, "host alpha"},
+		{[]string{"paths"}, "paths /etc,/home"},
+		{[]string{"host", "paths"}, "host alpha, paths /etc,/home"},
+		{[]string{"tags"}, "tags env=prod,nightly"},
+	}
+	for _, test := range tests {
+		key := RetentionGroup(metadata, test.groupBy)
+		if key != test.key {
+			t.Errorf("RetentionGroup(%s) = %q, expected %q", strings.Join(test.groupBy, ","), key, test.key)
+		}
+	}
+
+	// sorting for the key must not reorder the snapshot's own fields
+	if metadata.ScannedDirectories[0] != "/home" || metadata.Tags[0] != "nightly" {
+		t.Errorf("RetentionGroup modified the metadata")
+	}
+}
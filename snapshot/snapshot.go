@@ -2,6 +2,7 @@ package snapshot
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
@@ -21,11 +22,18 @@ func New(repository *storage.Repository) (*Snapshot, error) {
 		return nil, err
 	}
 
-	//keypair := repository.GetKeypair()
+	snapshot := newSnapshot(repository, tx)
+
+	logger.Trace("%s: New()", snapshot.Metadata.Uuid)
+	return snapshot, nil
+}
+
+func newSnapshot(repository *storage.Repository, tx *storage.Transaction) *Snapshot {
+	keypair := repository.GetKeypair()
 	pubkey := []byte("")
-	//if keypair != nil {
-	//	pubkey = keypair.PublicKey
-	//}
+	if keypair != nil {
+		pubkey = keypair.PublicKey
+	}
 
 	snapshot := &Snapshot{
 		repository:  repository,
@@ -67,22 +75,22 @@ func New(repository *storage.Repository) (*Snapshot, error) {
 			ChunkToObjects:       make(map[string][]string),
 			ObjectToPathnames:    make(map[string][]string),
 			ContentTypeToObjects: make(map[string][]string),
+			HardLinks:            make(map[string]string),
 		},
 	}
-
-	logger.Trace("%s: New()", snapshot.Metadata.Uuid)
-	return snapshot, nil
+	return snapshot
 }
 
 func Load(repository *storage.Repository, Uuid string) (*Snapshot, error) {
-	metadata, _, err := GetMetadata(repository, Uuid)
+	metadata, verified, err := GetMetadata(repository, Uuid)
 	if err != nil {
 		return nil, err
 	}
 
-	//if repository.Keypair != nil && !verified {
-	//	return nil, fmt.Errorf("signature mismatches for metadata")
-	//}
+	err = VerifyTrust(repository, metadata, verified)
+	if err != nil {
+		return nil, err
+	}
 
 	index, checksum, err := GetIndex(repository, Uuid)
 	if err != nil {
@@ -97,6 +105,7 @@ func Load(repository *storage.Repository, Uuid string) (*Snapshot, error) {
 	snapshot.repository = repository
 	snapshot.Metadata = metadata
 	snapshot.Index = index
+	snapshot.verified = verified
 
 	return snapshot, nil
 }
@@ -104,7 +113,6 @@ func Load(repository *storage.Repository, Uuid string) (*Snapshot, error) {
 func GetMetadata(repository *storage.Repository, Uuid string) (*Metadata, bool, error) {
 	cache := repository.GetCache()
 	secret := repository.GetSecret()
-	//keypair := repository.GetKeypair()
 
 	var orig_buffer []byte
 	var buffer []byte
@@ -133,7 +141,7 @@ func GetMetadata(repository *storage.Repository, Uuid string) (*Metadata, bool,
 	orig_buffer = buffer
 
 	if secret != nil {
-		tmp, err := encryption.Decrypt(secret, buffer)
+		tmp, err := encryption.Decrypt(secret, buffer, encryption.AssociatedData("metadata", Uuid))
 		if err != nil {
 			return nil, false, err
 		}
@@ -148,34 +156,85 @@ func GetMetadata(repository *storage.Repository, Uuid string) (*Metadata, bool,
 		buffer = tmp
 	}
 
-	//signature := []byte("")
-	//if keypair != nil {
-	//	tmp, sigbuf := buffer[0:len(buffer)-64], buffer[len(buffer)-64:]
-	//	buffer = tmp
-	//	signature = append(signature, sigbuf...)
-	//}
-
+	// signed metadata is followed by the signature, which makes it
+	// invalid JSON on its own and tells it apart from unsigned metadata
+	var signature []byte
 	metadata, err := metadataFromBytes(buffer)
 	if err != nil {
-		return nil, false, err
+		if len(buffer) <= ed25519.SignatureSize {
+			return nil, false, err
+		}
+		buffer, signature = buffer[:len(buffer)-ed25519.SignatureSize], buffer[len(buffer)-ed25519.SignatureSize:]
+		metadata, err = metadataFromBytes(buffer)
+		if err != nil {
+			return nil, false, err
+		}
 	}
 
-	//verified := false
-	//if keypair != nil {
-	//	publicKey, err := base64.StdEncoding.DecodeString(metadata.PublicKey)
-	//	if err != nil {
-	//		return nil, false, err
-	//	}
-	//
-	//	verified = ed25519.Verify(ed25519.PublicKey(publicKey), buffer, signature)
-	//}
+	verified := false
+	if signature != nil {
+		publicKey, err := base64.StdEncoding.DecodeString(metadata.PublicKey)
+		if err != nil {
+			return nil, false, err
+		}
+
+		verified = encryption.Verify(publicKey, buffer, signature)
+		if !verified {
+			return nil, false, fmt.Errorf("%s: signature mismatches for metadata", Uuid)
+		}
+	}
 
 	if cache != nil && cacheMiss {
 		logger.Trace("snapshot: cache.PutMetadata(%s)", Uuid)
 		cache.PutMetadata(repository.Configuration().Uuid, metadata.Uuid, orig_buffer)
 	}
 
-	return metadata, false, nil
+	return metadata, verified, nil
+}
+
+// VerifyTrust checks that a snapshot was signed by a trusted key, which
+// is only enforced when trusted keys are configured. The index checksum
+// is part of the signed metadata so the index is covered as well.
+func VerifyTrust(repository *storage.Repository, metadata *Metadata, verified bool) error {
+	trustedKeys := repository.GetTrustedKeys()
+	if len(trustedKeys) == 0 {
+		return nil
+	}
+
+	if !verified {
+		return fmt.Errorf("%s: snapshot is not signed", metadata.Uuid)
+	}
+	if !IsTrustedKey(repository, metadata.PublicKey) {
+		return fmt.Errorf("%s: snapshot is signed by an untrusted key", metadata.Uuid)
+	}
+	return nil
+}
+
+// SignatureStatus describes how a snapshot is signed, for display.
+func SignatureStatus(repository *storage.Repository, metadata *Metadata, verified bool) string {
+	if !verified {
+		return "unsigned"
+	}
+	if len(repository.GetTrustedKeys()) == 0 {
+		return "valid"
+	}
+	if !IsTrustedKey(repository, metadata.PublicKey) {
+		return "valid, untrusted key"
+	}
+	return "valid, trusted key"
+}
+
+func (snapshot *Snapshot) IsSigned() bool {
+	return snapshot.verified
+}
+
+func IsTrustedKey(repository *storage.Repository, publicKey string) bool {
+	for _, trustedKey := range repository.GetTrustedKeys() {
+		if base64.StdEncoding.EncodeToString(trustedKey) == publicKey {
+			return true
+		}
+	}
+	return false
 }
 
 func GetIndex(repository *storage.Repository, Uuid string) (*Index, []byte, error) {
@@ -209,7 +268,7 @@ func GetIndex(repository *storage.Repository, Uuid string) (*Index, []byte, erro
 	orig_buffer = buffer
 
 	if secret != nil {
-		tmp, err := encryption.Decrypt(secret, buffer)
+		tmp, err := encryption.Decrypt(secret, buffer, encryption.AssociatedData("index", Uuid))
 		if err != nil {
 			return nil, nil, err
 		}
@@ -250,16 +309,33 @@ func (snapshot *Snapshot) GetChunkInfo(checksum string) (*Chunk, bool) {
 	return chunk, exists
 }
 
-func (snapshot *Snapshot) PutChunk(checksum string, data []byte) error {
+func (snapshot *Snapshot) PutChunk(checksum string, data []byte, compressible bool) error {
 	secret := snapshot.repository.GetSecret()
+	publicKey := snapshot.repository.GetPublicKey()
 
 	buffer := data
 	if snapshot.repository.Configuration().Compression != "" {
-		buffer = compression.Deflate(buffer)
+		// the codec is recorded in the blob header, so chunks that are
+		// not worth compressing are stored with the "none" codec
+		codec := snapshot.repository.Configuration().Compression
+		if !compressible {
+			codec = "none"
+		}
+		tmp, err := compression.Deflate(codec, buffer)
+		if err != nil {
+			return err
+		}
+		buffer = tmp
 	}
 
 	if secret != nil {
-		tmp, err := encryption.Encrypt(secret, buffer)
+		tmp, err := encryption.Encrypt(secret, buffer, encryption.AssociatedData("chunk", checksum))
+		if err != nil {
+			return err
+		}
+		buffer = tmp
+	} else if publicKey != nil {
+		tmp, err := encryption.EncryptToPublicKey(publicKey, buffer, encryption.AssociatedData("chunk", checksum))
 		if err != nil {
 			return err
 		}
@@ -272,14 +348,25 @@ func (snapshot *Snapshot) PutChunk(checksum string, data []byte) error {
 
 func (snapshot *Snapshot) PutObject(checksum string, data []byte) error {
 	secret := snapshot.repository.GetSecret()
+	publicKey := snapshot.repository.GetPublicKey()
 
 	buffer := data
 	if snapshot.repository.Configuration().Compression != "" {
-		buffer = compression.Deflate(buffer)
+		tmp, err := compression.Deflate(snapshot.repository.Configuration().Compression, buffer)
+		if err != nil {
+			return err
+		}
+		buffer = tmp
 	}
 
 	if secret != nil {
-		tmp, err := encryption.Encrypt(secret, buffer)
+		tmp, err := encryption.Encrypt(secret, buffer, encryption.AssociatedData("object", checksum))
+		if err != nil {
+			return err
+		}
+		buffer = tmp
+	} else if publicKey != nil {
+		tmp, err := encryption.EncryptToPublicKey(publicKey, buffer, encryption.AssociatedData("object", checksum))
 		if err != nil {
 			return err
 		}
@@ -292,15 +379,26 @@ func (snapshot *Snapshot) PutObject(checksum string, data []byte) error {
 
 func (snapshot *Snapshot) PutMetadata(data []byte) error {
 	secret := snapshot.repository.GetSecret()
+	publicKey := snapshot.repository.GetPublicKey()
 
 	buffer := data
 
 	if snapshot.repository.Configuration().Compression != "" {
-		buffer = compression.Deflate(buffer)
+		tmp, err := compression.Deflate(snapshot.repository.Configuration().Compression, buffer)
+		if err != nil {
+			return err
+		}
+		buffer = tmp
 	}
 
 	if secret != nil {
-		tmp, err := encryption.Encrypt(secret, buffer)
+		tmp, err := encryption.Encrypt(secret, buffer, encryption.AssociatedData("metadata", snapshot.Metadata.Uuid))
+		if err != nil {
+			return err
+		}
+		buffer = tmp
+	} else if publicKey != nil {
+		tmp, err := encryption.EncryptToPublicKey(publicKey, buffer, encryption.AssociatedData("metadata", snapshot.Metadata.Uuid))
 		if err != nil {
 			return err
 		}
@@ -313,15 +411,26 @@ func (snapshot *Snapshot) PutMetadata(data []byte) error {
 
 func (snapshot *Snapshot) PutIndex(data []byte) error {
 	secret := snapshot.repository.GetSecret()
+	publicKey := snapshot.repository.GetPublicKey()
 
 	buffer := data
 
 	if snapshot.repository.Configuration().Compression != "" {
-		buffer = compression.Deflate(buffer)
+		tmp, err := compression.Deflate(snapshot.repository.Configuration().Compression, buffer)
+		if err != nil {
+			return err
+		}
+		buffer = tmp
 	}
 
 	if secret != nil {
-		tmp, err := encryption.Encrypt(secret, buffer)
+		tmp, err := encryption.Encrypt(secret, buffer, encryption.AssociatedData("index", snapshot.Metadata.Uuid))
+		if err != nil {
+			return err
+		}
+		buffer = tmp
+	} else if publicKey != nil {
+		tmp, err := encryption.EncryptToPublicKey(publicKey, buffer, encryption.AssociatedData("index", snapshot.Metadata.Uuid))
 		if err != nil {
 			return err
 		}
@@ -345,14 +454,25 @@ func (snapshot *Snapshot) ReferenceObjects(keys []string) ([]bool, error) {
 func (snapshot *Snapshot) PutMetadataCache(data []byte) error {
 	cache := snapshot.repository.GetCache()
 	secret := snapshot.repository.GetSecret()
+	publicKey := snapshot.repository.GetPublicKey()
 
 	buffer := data
 	if snapshot.repository.Configuration().Compression != "" {
-		buffer = compression.Deflate(buffer)
+		tmp, err := compression.Deflate(snapshot.repository.Configuration().Compression, buffer)
+		if err != nil {
+			return err
+		}
+		buffer = tmp
 	}
 
 	if secret != nil {
-		tmp, err := encryption.Encrypt(secret, buffer)
+		tmp, err := encryption.Encrypt(secret, buffer, encryption.AssociatedData("metadata", snapshot.Metadata.Uuid))
+		if err != nil {
+			return err
+		}
+		buffer = tmp
+	} else if publicKey != nil {
+		tmp, err := encryption.EncryptToPublicKey(publicKey, buffer, encryption.AssociatedData("metadata", snapshot.Metadata.Uuid))
 		if err != nil {
 			return err
 		}
@@ -366,14 +486,25 @@ func (snapshot *Snapshot) PutMetadataCache(data []byte) error {
 func (snapshot *Snapshot) PutIndexCache(data []byte) error {
 	cache := snapshot.repository.GetCache()
 	secret := snapshot.repository.GetSecret()
+	publicKey := snapshot.repository.GetPublicKey()
 
 	buffer := data
 	if snapshot.repository.Configuration().Compression != "" {
-		buffer = compression.Deflate(buffer)
+		tmp, err := compression.Deflate(snapshot.repository.Configuration().Compression, buffer)
+		if err != nil {
+			return err
+		}
+		buffer = tmp
 	}
 
 	if secret != nil {
-		tmp, err := encryption.Encrypt(secret, buffer)
+		tmp, err := encryption.Encrypt(secret, buffer, encryption.AssociatedData("index", snapshot.Metadata.Uuid))
+		if err != nil {
+			return err
+		}
+		buffer = tmp
+	} else if publicKey != nil {
+		tmp, err := encryption.EncryptToPublicKey(publicKey, buffer, encryption.AssociatedData("index", snapshot.Metadata.Uuid))
 		if err != nil {
 			return err
 		}
@@ -394,7 +525,7 @@ func (snapshot *Snapshot) GetChunk(checksum string) ([]byte, error) {
 	}
 
 	if secret != nil {
-		tmp, err := encryption.Decrypt(secret, buffer)
+		tmp, err := encryption.Decrypt(secret, buffer, encryption.AssociatedData("chunk", checksum))
 		if err != nil {
 			return nil, err
 		}
@@ -426,20 +557,23 @@ func (snapshot *Snapshot) GetObject(checksum string) (*Object, error) {
 	}
 
 	if secret != nil {
-		tmp, err := encryption.Decrypt(secret, buffer)
+		tmp, err := encryption.Decrypt(secret, buffer, encryption.AssociatedData("object", checksum))
 		if err != nil {
 			return nil, err
 		}
 		buffer = tmp
 	}
 
-	data, err := compression.Inflate(buffer)
-	if err != nil {
-		return nil, err
+	if snapshot.repository.Configuration().Compression != "" {
+		tmp, err := compression.Inflate(buffer)
+		if err != nil {
+			return nil, err
+		}
+		buffer = tmp
 	}
 
 	object := &Object{}
-	err = json.Unmarshal(data, &object)
+	err = json.Unmarshal(buffer, &object)
 	return object, err
 }
 
@@ -454,7 +588,7 @@ func (snapshot *Snapshot) CheckObject(checksum string) (bool, error) {
 
 func (snapshot *Snapshot) Commit() error {
 	cache := snapshot.repository.GetCache()
-	//keypair := snapshot.repository.GetKeypair()
+	keypair := snapshot.repository.GetKeypair()
 
 	serializedIndex, err := indexToBytes(snapshot.Index)
 	if err != nil {
@@ -468,13 +602,9 @@ func (snapshot *Snapshot) Commit() error {
 	if err != nil {
 		return err
 	}
-	//if keypair != nil {
-	//	tmp, err := keypair.Sign(serializedMetadata)
-	//	if err != nil {
-	//		return err
-	//	}
-	//	serializedMetadata = append(serializedMetadata, tmp...)
-	//}
+	if keypair != nil {
+		serializedMetadata = append(serializedMetadata, keypair.Sign(serializedMetadata)...)
+	}
 
 	err = snapshot.PutMetadata(serializedMetadata)
 	if err != nil {
@@ -495,6 +625,13 @@ func (snapshot *Snapshot) Commit() error {
 	return snapshot.transaction.Commit()
 }
 
+// Rollback discards a snapshot that was not committed along with the data
+// that only it referenced.
+func (snapshot *Snapshot) Rollback() error {
+	logger.Trace("%s: Rollback()", snapshot.Metadata.Uuid)
+	return snapshot.transaction.Rollback()
+}
+
 func (snapshot *Snapshot) StateSetChunkToObject(chunkChecksum string, objectChecksum string) {
 	snapshot.Index.muChunkToObjects.Lock()
 	defer snapshot.Index.muChunkToObjects.Unlock()
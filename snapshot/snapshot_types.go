@@ -1,6 +1,7 @@
 package snapshot
 
 import (
+	"os"
 	"sync"
 	"time"
 
@@ -18,6 +19,7 @@ type Object struct {
 	Checksum    string
 	Chunks      []string
 	ContentType string
+	Holes       []filesystem.Hole `json:",omitempty"`
 }
 
 // CachedObject needs to be killed
@@ -25,6 +27,7 @@ type CachedObject struct {
 	Checksum    string
 	Chunks      []*Chunk
 	ContentType string
+	Holes       []filesystem.Hole `json:",omitempty"`
 	Info        filesystem.Fileinfo
 }
 
@@ -36,6 +39,9 @@ type Statistics struct {
 	Directories uint64
 	NonRegular  uint64
 	Pathnames   uint64
+	Excluded    uint64
+	Skipped     uint64
+	HardLinks   uint64
 
 	Kind      map[string]uint64
 	Type      map[string]uint64
@@ -58,7 +64,11 @@ type Metadata struct {
 	Size         uint64
 	Checksum     []byte
 
+	// tags and key=value labels
+	Tags []string
+
 	ScannedDirectories []string
+	Skipped            []filesystem.SkippedPathname
 
 	IndexSize uint64
 
@@ -91,13 +101,30 @@ type Index struct {
 	// Content Type -> Object checksums
 	muContentTypeToObjects sync.Mutex
 	ContentTypeToObjects   map[string][]string
+
+	// Pathname -> first pathname of its hard link group
+	muHardLinks sync.Mutex
+	HardLinks   map[string]string
 }
 
 type Snapshot struct {
 	repository  *storage.Repository
 	transaction *storage.Transaction
+	verified    bool
+
+	// index of the last checkpoint of a resumed push
+	checkpoint *Index
+
+	SkipDirs      []string
+	Excludes      []string
+	OneFileSystem bool
+	SkipModes     os.FileMode
+	MaxSize       int64
+	Xattrs        bool
+	ACLs          bool
 
-	SkipDirs []string
+	// interval between checkpoints of a push in progress, none if zero
+	CheckpointInterval time.Duration
 
 	Metadata *Metadata
 	Index    *Index
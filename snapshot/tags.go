@@ -0,0 +1,134 @@
+package snapshot
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/poolpOrg/plakar/compression"
+	"github.com/poolpOrg/plakar/encryption"
+	"github.com/poolpOrg/plakar/logger"
+	"github.com/poolpOrg/plakar/storage"
+)
+
+// ValidateTag checks that a tag, or a key=value label, can be stored and
+// matched unambiguously.
+func ValidateTag(tag string) error {
+	if tag == "" || strings.HasPrefix(tag, "=") {
+		return fmt.Errorf("invalid tag: %q", tag)
+	}
+	for _, r := range tag {
+		if unicode.IsSpace(r) || r == ',' {
+			return fmt.Errorf("invalid tag: %q", tag)
+		}
+	}
+	return nil
+}
+
+// HasTag reports whether the snapshot carries a tag. A tag without a value
+// also matches the labels with that key.
+func (metadata *Metadata) HasTag(tag string) bool {
+	for _, t := range metadata.Tags {
+		if t == tag {
+			return true
+		}
+		if !strings.Contains(tag, "=") && strings.HasPrefix(t, tag+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// HasTags reports whether the snapshot carries all of the tags.
+func (metadata *Metadata) HasTags(tags []string) bool {
+	for _, tag := range tags {
+		if !metadata.HasTag(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// AddTag adds a tag to the snapshot, a label replaces the value of a label
+// with the same key.
+func (metadata *Metadata) AddTag(tag string) bool {
+	if metadata.HasTag(tag) {
+		return false
+	}
+	if i := strings.Index(tag, "="); i != -1 {
+		metadata.RemoveTag(tag[:i])
+	}
+	metadata.Tags = append(metadata.Tags, tag)
+	return true
+}
+
+// RemoveTag removes the tags that HasTag matches.
+func (metadata *Metadata) RemoveTag(tag string) bool {
+	tags := make([]string, 0, len(metadata.Tags))
+	for _, t := range metadata.Tags {
+		if t == tag || (!strings.Contains(tag, "=") && strings.HasPrefix(t, tag+"=")) {
+			continue
+		}
+		tags = append(tags, t)
+	}
+	removed := len(tags) != len(metadata.Tags)
+	metadata.Tags = tags
+	return removed
+}
+
+// UpdateMetadata rewrites the metadata of a committed snapshot. A signed
+// snapshot can only be rewritten with the keypair that signed it, so that
+// tagging never changes who the snapshot is attributed to. An unsigned
+// snapshot stays unsigned.
+func UpdateMetadata(repository *storage.Repository, metadata *Metadata, verified bool) error {
+	cache := repository.GetCache()
+	secret := repository.GetSecret()
+	keypair := repository.GetKeypair()
+
+	if verified {
+		if keypair == nil {
+			return fmt.Errorf("%s: snapshot is signed, its keypair is required to sign it again", metadata.Uuid)
+		}
+		if base64.StdEncoding.EncodeToString(keypair.PublicKey) != metadata.PublicKey {
+			return fmt.Errorf("%s: snapshot is signed by another key", metadata.Uuid)
+		}
+	}
+
+	serializedMetadata, err := metadataToBytes(metadata)
+	if err != nil {
+		return err
+	}
+	if verified {
+		serializedMetadata = append(serializedMetadata, keypair.Sign(serializedMetadata)...)
+	}
+
+	buffer := serializedMetadata
+	if repository.Configuration().Compression != "" {
+		tmp, err := compression.Deflate(repository.Configuration().Compression, buffer)
+		if err != nil {
+			return err
+		}
+		buffer = tmp
+	}
+
+	if secret != nil {
+		tmp, err := encryption.Encrypt(secret, buffer, encryption.AssociatedData("metadata", metadata.Uuid))
+		if err != nil {
+			return err
+		}
+		buffer = tmp
+	}
+
+	logger.Trace("snapshot: repository.PutMetadata(%s)", metadata.Uuid)
+	err = repository.PutMetadata(metadata.Uuid, buffer)
+	if err != nil {
+		return err
+	}
+
+	if cache != nil {
+		logger.Trace("snapshot: cache.PutMetadata(%s)", metadata.Uuid)
+		cache.PutMetadata(repository.Configuration().Uuid, metadata.Uuid, buffer)
+	}
+	return nil
+}
@@ -24,6 +24,7 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/poolpOrg/plakar/network"
@@ -339,6 +340,22 @@ func (repository *ClientRepository) Configuration() storage.RepositoryConfig {
 	return repository.config
 }
 
+func (repository *ClientRepository) SetConfiguration(config storage.RepositoryConfig) error {
+	result, err := repository.sendRequest("ReqSetConfiguration", network.ReqSetConfiguration{
+		RepositoryConfig: config,
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.Payload.(network.ResSetConfiguration).Err != nil {
+		return result.Payload.(network.ResSetConfiguration).Err
+	}
+
+	repository.config = config
+	return nil
+}
+
 func (repository *ClientRepository) Transaction() (storage.TransactionBackend, error) {
 	result, err := repository.sendRequest("ReqTransaction", nil)
 	if err != nil {
@@ -355,6 +372,60 @@ func (repository *ClientRepository) Transaction() (storage.TransactionBackend, e
 	return tx, nil
 }
 
+func (repository *ClientRepository) GetTransactions() ([]string, error) {
+	result, err := repository.sendRequest("ReqGetTransactions", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Payload.(network.ResGetTransactions).Transactions, result.Payload.(network.ResGetTransactions).Err
+}
+
+func (repository *ClientRepository) GetTransactionMetadata(Uuid string) ([]byte, error) {
+	result, err := repository.sendRequest("ReqGetTransactionMetadata", network.ReqGetTransactionMetadata{
+		Uuid: Uuid,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Payload.(network.ResGetTransactionMetadata).Data, result.Payload.(network.ResGetTransactionMetadata).Err
+}
+
+func (repository *ClientRepository) GetTransactionIndex(Uuid string) ([]byte, error) {
+	result, err := repository.sendRequest("ReqGetTransactionIndex", network.ReqGetTransactionIndex{
+		Uuid: Uuid,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Payload.(network.ResGetTransactionIndex).Data, result.Payload.(network.ResGetTransactionIndex).Err
+}
+
+func (repository *ClientRepository) ResumeTransaction(Uuid string) (storage.TransactionBackend, error) {
+	result, err := repository.sendRequest("ReqResumeTransaction", network.ReqResumeTransaction{
+		Uuid: Uuid,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	Uuid, err = result.Payload.(network.ResResumeTransaction).Uuid, result.Payload.(network.ResResumeTransaction).Err
+	if err != nil {
+		// only the message is sent over the wire, callers need to tell a
+		// transaction in use apart from other failures
+		if strings.HasSuffix(err.Error(), storage.ErrTransactionInUse.Error()) {
+			return nil, fmt.Errorf("%s: %w", Uuid, storage.ErrTransactionInUse)
+		}
+		return nil, err
+	}
+	tx := &ClientTransaction{}
+	tx.Uuid = Uuid
+	tx.repository = repository
+	return tx, nil
+}
+
 func (repository *ClientRepository) GetIndexes() ([]string, error) {
 	result, err := repository.sendRequest("ReqGetIndexes", nil)
 	if err != nil {
@@ -470,6 +541,74 @@ func (repository *ClientRepository) GetChunkSize(checksum string) (uint64, error
 	return result.Payload.(network.ResGetChunkSize).Size, result.Payload.(network.ResGetChunkSize).Err
 }
 
+func (repository *ClientRepository) DeleteObject(checksum string) (bool, error) {
+	result, err := repository.sendRequest("ReqDeleteObject", network.ReqDeleteObject{
+		Checksum: checksum,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return result.Payload.(network.ResDeleteObject).Deleted, result.Payload.(network.ResDeleteObject).Err
+}
+
+func (repository *ClientRepository) DeleteChunk(checksum string) (bool, error) {
+	result, err := repository.sendRequest("ReqDeleteChunk", network.ReqDeleteChunk{
+		Checksum: checksum,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return result.Payload.(network.ResDeleteChunk).Deleted, result.Payload.(network.ResDeleteChunk).Err
+}
+
+func (repository *ClientRepository) GetHold(id string) ([]byte, error) {
+	result, err := repository.sendRequest("ReqGetHold", network.ReqGetHold{
+		Uuid: id,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Payload.(network.ResGetHold).Data, result.Payload.(network.ResGetHold).Err
+}
+
+func (repository *ClientRepository) PutHold(id string, data []byte) error {
+	result, err := repository.sendRequest("ReqPutHold", network.ReqPutHold{
+		Uuid: id,
+		Data: data,
+	})
+	if err != nil {
+		return err
+	}
+
+	return result.Payload.(network.ResPutHold).Err
+}
+
+func (repository *ClientRepository) PutMetadata(id string, data []byte) error {
+	result, err := repository.sendRequest("ReqPutSnapshotMetadata", network.ReqPutSnapshotMetadata{
+		Uuid: id,
+		Data: data,
+	})
+	if err != nil {
+		return err
+	}
+
+	return result.Payload.(network.ResPutSnapshotMetadata).Err
+}
+
+func (repository *ClientRepository) DeleteHold(id string) error {
+	result, err := repository.sendRequest("ReqDeleteHold", network.ReqDeleteHold{
+		Uuid: id,
+	})
+	if err != nil {
+		return err
+	}
+
+	return result.Payload.(network.ResDeleteHold).Err
+}
+
 func (repository *ClientRepository) Purge(id string) error {
 	result, err := repository.sendRequest("ReqPurge", network.ReqPurge{
 		Uuid: id,
@@ -481,6 +620,26 @@ func (repository *ClientRepository) Purge(id string) error {
 	return result.Payload.(network.ResPurge).Err
 }
 
+func (repository *ClientRepository) Lock(exclusive bool) error {
+	result, err := repository.sendRequest("ReqLock", network.ReqLock{
+		Exclusive: exclusive,
+	})
+	if err != nil {
+		return err
+	}
+
+	return result.Payload.(network.ResLock).Err
+}
+
+func (repository *ClientRepository) Unlock() error {
+	result, err := repository.sendRequest("ReqUnlock", network.ReqUnlock{})
+	if err != nil {
+		return err
+	}
+
+	return result.Payload.(network.ResUnlock).Err
+}
+
 func (repository *ClientRepository) Close() error {
 	result, err := repository.sendRequest("ReqClose", nil)
 	if err != nil {
@@ -584,3 +743,14 @@ func (transaction *ClientTransaction) Commit() error {
 	}
 	return result.Payload.(network.ResCommit).Err
 }
+
+func (transaction *ClientTransaction) Rollback() error {
+	repository := transaction.repository
+	result, err := repository.sendRequest("ReqRollback", network.ReqRollback{
+		Transaction: transaction.GetUuid(),
+	})
+	if err != nil {
+		return err
+	}
+	return result.Payload.(network.ResRollback).Err
+}
@@ -51,9 +51,18 @@ type DatabaseRepository struct {
 
 	Repository string
 
+	lock *DatabaseLock
+
 	storage.RepositoryBackend
 }
 
+type DatabaseLock struct {
+	Uuid string
+	info storage.LockInfo
+	stop chan bool
+	done chan bool
+}
+
 type DatabaseTransaction struct {
 	Uuid       string
 	repository *DatabaseRepository
@@ -62,6 +71,19 @@ type DatabaseTransaction struct {
 	storage.TransactionBackend
 }
 
+const locksTable = `CREATE TABLE IF NOT EXISTS locks (
+		lockUuid		VARCHAR(36) NOT NULL PRIMARY KEY,
+		lockHostname	VARCHAR(255) NOT NULL,
+		lockPid			INTEGER NOT NULL,
+		lockExclusive	BOOLEAN NOT NULL,
+		lockTimestamp	INTEGER NOT NULL
+	);`
+
+const holdsTable = `CREATE TABLE IF NOT EXISTS holds (
+		holdUuid	VARCHAR(36) NOT NULL PRIMARY KEY,
+		holdBlob	BLOB
+	);`
+
 func init() {
 	storage.Register("database", NewDatabaseRepository)
 }
@@ -183,6 +205,20 @@ func (repository *DatabaseRepository) Create(location string, config storage.Rep
 	defer statement.Close()
 	statement.Exec()
 
+	statement, err = repository.conn.Prepare(locksTable)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	statement.Exec()
+
+	statement, err = repository.conn.Prepare(holdsTable)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	statement.Exec()
+
 	statement, err = repository.conn.Prepare(`INSERT INTO configuration(configKey, configValue) VALUES(?, ?)`)
 	defer statement.Close()
 	if err != nil {
@@ -227,6 +263,15 @@ func (repository *DatabaseRepository) Open(location string) error {
 	}
 	repository.config = repositoryConfig
 
+	// repositories created by previous versions lack the tables that were
+	// introduced since
+	for _, table := range []string{locksTable, holdsTable} {
+		_, err = repository.conn.Exec(table)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 
 }
@@ -235,6 +280,28 @@ func (repository *DatabaseRepository) Configuration() storage.RepositoryConfig {
 	return repository.config
 }
 
+func (repository *DatabaseRepository) SetConfiguration(config storage.RepositoryConfig) error {
+	statement, err := repository.conn.Prepare(`INSERT OR REPLACE INTO configuration(configKey, configValue) VALUES(?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec("Compression", config.Compression)
+	if err != nil {
+		return err
+	}
+
+	_, err = statement.Exec("Encryption", config.Encryption)
+	if err != nil {
+		return err
+	}
+
+	repository.config = config
+
+	return nil
+}
+
 func (repository *DatabaseRepository) Transaction() (storage.TransactionBackend, error) {
 	Uuid, err := uuid.NewRandom()
 	if err != nil {
@@ -254,6 +321,25 @@ func (repository *DatabaseRepository) Transaction() (storage.TransactionBackend,
 	return tx, nil
 }
 
+// database transactions are rolled back if they are interrupted, so there
+// are never incomplete transactions to resume
+
+func (repository *DatabaseRepository) GetTransactions() ([]string, error) {
+	return []string{}, nil
+}
+
+func (repository *DatabaseRepository) GetTransactionMetadata(Uuid string) ([]byte, error) {
+	return nil, fmt.Errorf("%s: no such transaction", Uuid)
+}
+
+func (repository *DatabaseRepository) GetTransactionIndex(Uuid string) ([]byte, error) {
+	return nil, fmt.Errorf("%s: no such transaction", Uuid)
+}
+
+func (repository *DatabaseRepository) ResumeTransaction(Uuid string) (storage.TransactionBackend, error) {
+	return nil, fmt.Errorf("%s: no such transaction", Uuid)
+}
+
 func (repository *DatabaseRepository) GetIndexes() ([]string, error) {
 	rows, err := repository.conn.Query("SELECT indexUuid FROM indexes")
 	if err != nil {
@@ -365,12 +451,243 @@ func (repository *DatabaseRepository) CheckChunk(checksum string) (bool, error)
 	return true, nil
 }
 
+func (repository *DatabaseRepository) GetObjectSize(checksum string) (uint64, error) {
+	var size uint64
+	err := repository.conn.QueryRow(`SELECT length(objectBlob) FROM objects WHERE objectChecksum=?`, checksum).Scan(&size)
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+func (repository *DatabaseRepository) GetChunkSize(checksum string) (uint64, error) {
+	var size uint64
+	err := repository.conn.QueryRow(`SELECT length(chunkBlob) FROM chunks WHERE chunkChecksum=?`, checksum).Scan(&size)
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+func (repository *DatabaseRepository) GetObjectRefCount(checksum string) (uint64, error) {
+	var count uint64
+	err := repository.conn.QueryRow(`SELECT COUNT(*) FROM objectsReferences WHERE objectChecksum=?`, checksum).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (repository *DatabaseRepository) GetChunkRefCount(checksum string) (uint64, error) {
+	var count uint64
+	err := repository.conn.QueryRow(`SELECT COUNT(*) FROM chunksReferences WHERE chunkChecksum=?`, checksum).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// references are recorded as soon as a transaction is opened and writers
+// are serialized, a blob that nothing references can't be in use
+func (repository *DatabaseRepository) DeleteObject(checksum string) (bool, error) {
+	res, err := repository.conn.Exec(`DELETE FROM objects WHERE objectChecksum=? AND NOT EXISTS (SELECT 1 FROM objectsReferences WHERE objectChecksum=?)`, checksum, checksum)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count != 0, nil
+}
+
+func (repository *DatabaseRepository) DeleteChunk(checksum string) (bool, error) {
+	res, err := repository.conn.Exec(`DELETE FROM chunks WHERE chunkChecksum=? AND NOT EXISTS (SELECT 1 FROM chunksReferences WHERE chunkChecksum=?)`, checksum, checksum)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count != 0, nil
+}
+
+// PutMetadata rewrites the metadata of a committed snapshot
+func (repository *DatabaseRepository) PutMetadata(id string, data []byte) error {
+	res, err := repository.conn.Exec(`UPDATE metadatas SET metadataBlob=? WHERE metadataUuid=?`, data, id)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("%s: no such snapshot", id)
+	}
+	return nil
+}
+
+func (repository *DatabaseRepository) GetHold(id string) ([]byte, error) {
+	var data []byte
+	err := repository.conn.QueryRow(`SELECT holdBlob FROM holds WHERE holdUuid=?`, id).Scan(&data)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (repository *DatabaseRepository) PutHold(id string, data []byte) error {
+	var Uuid string
+	err := repository.conn.QueryRow(`SELECT indexUuid FROM indexes WHERE indexUuid=?`, id).Scan(&Uuid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%s: no such snapshot", id)
+		}
+		return err
+	}
+
+	_, err = repository.conn.Exec(`INSERT OR REPLACE INTO holds (holdUuid, holdBlob) VALUES(?, ?)`, id, data)
+	return err
+}
+
+func (repository *DatabaseRepository) DeleteHold(id string) error {
+	_, err := repository.conn.Exec(`DELETE FROM holds WHERE holdUuid=?`, id)
+	return err
+}
+
 func (repository *DatabaseRepository) Purge(id string) error {
+	tx, err := repository.conn.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		`DELETE FROM chunksReferences WHERE indexUuid=?`,
+		`DELETE FROM objectsReferences WHERE indexUuid=?`,
+		`DELETE FROM indexes WHERE indexUuid=?`,
+		`DELETE FROM metadatas WHERE metadataUuid=?`,
+		`DELETE FROM holds WHERE holdUuid=?`,
+	} {
+		_, err = tx.Exec(query, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (repository *DatabaseRepository) Lock(exclusive bool) error {
+	if repository.lock != nil {
+		return fmt.Errorf("repository is already locked")
+	}
+
+	lock := &DatabaseLock{
+		Uuid: uuid.New().String(),
+		info: storage.NewLockInfo(exclusive),
+		stop: make(chan bool),
+		done: make(chan bool),
+	}
+
+	// conflicting locks are looked up and the new one inserted within a
+	// single transaction, sqlite serializes writers
+	tx, err := repository.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT lockUuid, lockHostname, lockPid, lockExclusive, lockTimestamp FROM locks`)
+	if err != nil {
+		return err
+	}
+	stale := make([]string, 0)
+	var conflict *storage.LockInfo
+	for rows.Next() {
+		var lockUuid string
+		var timestamp int64
+		info := storage.LockInfo{}
+		err = rows.Scan(&lockUuid, &info.Hostname, &info.Pid, &info.Exclusive, &timestamp)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		info.Timestamp = time.Unix(timestamp, 0)
+
+		if info.IsStale() {
+			stale = append(stale, lockUuid)
+		} else if info.Conflicts(exclusive) && conflict == nil {
+			conflict = &info
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if conflict != nil {
+		return conflict.Error()
+	}
+
+	for _, lockUuid := range stale {
+		logger.Trace("database: removing stale lock %s", lockUuid)
+		_, err = tx.Exec(`DELETE FROM locks WHERE lockUuid=?`, lockUuid)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO locks (lockUuid, lockHostname, lockPid, lockExclusive, lockTimestamp) VALUES(?, ?, ?, ?, ?)`,
+		lock.Uuid, lock.info.Hostname, lock.info.Pid, lock.info.Exclusive, lock.info.Timestamp.Unix())
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	repository.lock = lock
+	go func() {
+		defer close(lock.done)
+		ticker := time.NewTicker(storage.LockRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-lock.stop:
+				return
+			case <-ticker.C:
+				_, err := repository.conn.Exec(`UPDATE locks SET lockTimestamp=? WHERE lockUuid=?`, time.Now().Unix(), lock.Uuid)
+				if err != nil {
+					logger.Warn("could not refresh lock: %s", err)
+				}
+			}
+		}
+	}()
+
 	return nil
 }
 
+func (repository *DatabaseRepository) Unlock() error {
+	lock := repository.lock
+	if lock == nil {
+		return nil
+	}
+
+	close(lock.stop)
+	<-lock.done
+	repository.lock = nil
+
+	_, err := repository.conn.Exec(`DELETE FROM locks WHERE lockUuid=?`, lock.Uuid)
+	return err
+}
+
 func (repository *DatabaseRepository) Close() error {
-	return nil
+	return repository.Unlock()
 }
 
 //////
@@ -457,7 +774,7 @@ func (transaction *DatabaseTransaction) PutChunk(checksum string, data []byte) e
 }
 
 func (transaction *DatabaseTransaction) PutMetadata(data []byte) error {
-	statement, err := transaction.dbTx.Prepare(`INSERT INTO metadatas (metadataUuid, metadataBlob) VALUES(?, ?)`)
+	statement, err := transaction.dbTx.Prepare(`INSERT OR REPLACE INTO metadatas (metadataUuid, metadataBlob) VALUES(?, ?)`)
 	if err != nil {
 		return err
 	}
@@ -472,7 +789,7 @@ func (transaction *DatabaseTransaction) PutMetadata(data []byte) error {
 }
 
 func (transaction *DatabaseTransaction) PutIndex(data []byte) error {
-	statement, err := transaction.dbTx.Prepare(`INSERT INTO indexes (indexUuid, indexBlob) VALUES(?, ?)`)
+	statement, err := transaction.dbTx.Prepare(`INSERT OR REPLACE INTO indexes (indexUuid, indexBlob) VALUES(?, ?)`)
 	if err != nil {
 		return err
 	}
@@ -489,3 +806,7 @@ func (transaction *DatabaseTransaction) PutIndex(data []byte) error {
 func (transaction *DatabaseTransaction) Commit() error {
 	return transaction.dbTx.Commit()
 }
+
+func (transaction *DatabaseTransaction) Rollback() error {
+	return transaction.dbTx.Rollback()
+}
@@ -24,6 +24,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -44,16 +45,30 @@ type FSRepository struct {
 
 	Repository string
 	root       string
-	dirty      bool
+
+	// chunks and objects may have been left unreferenced since the last
+	// tidy, shared by all transactions of the repository
+	muDirty sync.Mutex
+	dirty   bool
+
+	// transactions neither committed nor rolled back yet
+	muTransactions sync.Mutex
+	transactions   map[string]*FSTransaction
+
+	lock *FSLock
 
 	storage.RepositoryBackend
 }
 
 type FSTransaction struct {
 	Uuid       string
-	repository FSRepository
+	repository *FSRepository
 	prepared   bool
 
+	// held for as long as the transaction is in progress, the kernel
+	// releases it if the process dies which makes the transaction stale
+	lock *os.File
+
 	//SkipDirs []string
 
 	chunksMutex  sync.Mutex
@@ -104,6 +119,7 @@ func (repository *FSRepository) Create(location string, config storage.Repositor
 	os.MkdirAll(fmt.Sprintf("%s/transactions", repository.root), 0700)
 	os.MkdirAll(fmt.Sprintf("%s/snapshots", repository.root), 0700)
 	os.MkdirAll(fmt.Sprintf("%s/purge", repository.root), 0700)
+	os.MkdirAll(fmt.Sprintf("%s/locks", repository.root), 0700)
 
 	for i := 0; i < 256; i++ {
 		os.MkdirAll(fmt.Sprintf("%s/chunks/%02x", repository.root, i), 0700)
@@ -112,18 +128,24 @@ func (repository *FSRepository) Create(location string, config storage.Repositor
 		os.MkdirAll(fmt.Sprintf("%s/snapshots/%02x", repository.root, i), 0700)
 	}
 
-	f, err := os.Create(fmt.Sprintf("%s/CONFIG", repository.root))
+	return repository.SetConfiguration(config)
+}
+
+func (repository *FSRepository) Open(location string) error {
+	repository.root = location
+
+	compressed, err := ioutil.ReadFile(fmt.Sprintf("%s/CONFIG", repository.root))
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	jconfig, err := json.Marshal(config)
+	jconfig, err := compression.Inflate(compressed)
 	if err != nil {
 		return err
 	}
 
-	_, err = f.Write(compression.Deflate(jconfig))
+	config := storage.RepositoryConfig{}
+	err = json.Unmarshal(jconfig, &config)
 	if err != nil {
 		return err
 	}
@@ -133,22 +155,38 @@ func (repository *FSRepository) Create(location string, config storage.Repositor
 	return nil
 }
 
-func (repository *FSRepository) Open(location string) error {
-	repository.root = location
+func (repository *FSRepository) Configuration() storage.RepositoryConfig {
+	return repository.config
+}
 
-	compressed, err := ioutil.ReadFile(fmt.Sprintf("%s/CONFIG", repository.root))
+func (repository *FSRepository) SetConfiguration(config storage.RepositoryConfig) error {
+	jconfig, err := json.Marshal(config)
 	if err != nil {
 		return err
 	}
 
-	jconfig, err := compression.Inflate(compressed)
+	compressed, err := compression.Deflate("gzip", jconfig)
 	if err != nil {
 		return err
 	}
 
-	config := storage.RepositoryConfig{}
-	err = json.Unmarshal(jconfig, &config)
+	// write to a temporary file and rename so that an interrupted
+	// update never leaves the repository without a configuration
+	f, err := ioutil.TempFile(repository.root, "CONFIG.*")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(compressed)
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	err = os.Rename(f.Name(), fmt.Sprintf("%s/CONFIG", repository.root))
 	if err != nil {
+		os.Remove(f.Name())
 		return err
 	}
 
@@ -157,16 +195,78 @@ func (repository *FSRepository) Open(location string) error {
 	return nil
 }
 
-func (repository *FSRepository) Configuration() storage.RepositoryConfig {
-	return repository.config
-}
-
 func (repository *FSRepository) Transaction() (storage.TransactionBackend, error) {
 	// XXX - keep a map of current transactions
 
 	tx := &FSTransaction{}
 	tx.Uuid = uuid.New().String()
-	tx.repository = *repository
+	tx.repository = repository
+	tx.prepared = false
+
+	tx.chunks = make(map[string]bool)
+	tx.objects = make(map[string]bool)
+	tx.chunkBucket = make(map[string]bool)
+	tx.objectBucket = make(map[string]bool)
+
+	err := tx.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+func (repository *FSRepository) GetTransactions() ([]string, error) {
+	ret := make([]string, 0)
+
+	buckets, err := ioutil.ReadDir(repository.PathTransactions())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bucket := range buckets {
+		transactions, err := ioutil.ReadDir(fmt.Sprintf("%s/%s", repository.PathTransactions(), bucket.Name()))
+		if err != nil {
+			return ret, err
+		}
+		for _, transaction := range transactions {
+			if _, err := uuid.Parse(transaction.Name()); err != nil {
+				continue
+			}
+			ret = append(ret, transaction.Name())
+		}
+	}
+	return ret, nil
+}
+
+func (repository *FSRepository) GetTransactionMetadata(Uuid string) ([]byte, error) {
+	parsedUuid, err := uuid.Parse(Uuid)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(fmt.Sprintf("%s/METADATA", repository.PathTransaction(parsedUuid.String())))
+}
+
+func (repository *FSRepository) GetTransactionIndex(Uuid string) ([]byte, error) {
+	parsedUuid, err := uuid.Parse(Uuid)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(fmt.Sprintf("%s/INDEX", repository.PathTransaction(parsedUuid.String())))
+}
+
+func (repository *FSRepository) ResumeTransaction(Uuid string) (storage.TransactionBackend, error) {
+	parsedUuid, err := uuid.Parse(Uuid)
+	if err != nil {
+		return nil, err
+	}
+	if !pathnameExists(repository.PathTransaction(parsedUuid.String())) {
+		return nil, fmt.Errorf("%s: no such transaction", Uuid)
+	}
+
+	tx := &FSTransaction{}
+	tx.Uuid = parsedUuid.String()
+	tx.repository = repository
 	tx.prepared = false
 
 	tx.chunks = make(map[string]bool)
@@ -174,7 +274,10 @@ func (repository *FSRepository) Transaction() (storage.TransactionBackend, error
 	tx.chunkBucket = make(map[string]bool)
 	tx.objectBucket = make(map[string]bool)
 
-	tx.prepare()
+	err = tx.prepare()
+	if err != nil {
+		return nil, err
+	}
 
 	return tx, nil
 }
@@ -237,17 +340,7 @@ func (repository *FSRepository) PutMetadata(id string, data []byte) error {
 	os.Mkdir(repository.PathIndexObjects(id), 0700)
 	os.Mkdir(repository.PathIndexChunks(id), 0700)
 
-	f, err := os.Create(fmt.Sprintf("%s/METADATA", repository.PathIndex(id)))
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	_, err = f.Write(data)
-	if err != nil {
-		return err
-	}
-	return nil
+	return putFile(repository.PathIndex(id), "METADATA", data)
 }
 
 func (repository *FSRepository) PutIndex(id string, data []byte) error {
@@ -296,10 +389,10 @@ func (repository *FSRepository) GetObjects() ([]string, error) {
 		}
 
 		for _, object := range objects {
-			//_, err = uuid.Parse(object.Name())
-			//if err != nil {
-			//		return ret, nil
-			//	}
+			// temporary files of writes in progress
+			if strings.Contains(object.Name(), ".") {
+				continue
+			}
 			ret = append(ret, object.Name())
 		}
 	}
@@ -365,6 +458,43 @@ func (repository *FSRepository) GetChunkSize(checksum string) (uint64, error) {
 	return uint64(st.Size()), nil
 }
 
+func (repository *FSRepository) DeleteObject(checksum string) (bool, error) {
+	return repository.deleteBlob(repository.PathObject(checksum))
+}
+
+func (repository *FSRepository) DeleteChunk(checksum string) (bool, error) {
+	return repository.deleteBlob(repository.PathChunk(checksum))
+}
+
+// deleteBlob removes a chunk or object unless it is still hard-linked from
+// a snapshot or a transaction. The blob is moved out of the way before its
+// link count is checked so a transaction can't reference it in between, a
+// transaction trying to do so finds it missing and stores it again.
+func (repository *FSRepository) deleteBlob(pathname string) (bool, error) {
+	dest := fmt.Sprintf("%s/%s", repository.PathPurge(), uuid.New().String())
+	err := os.Rename(pathname, dest)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	st, err := os.Stat(dest)
+	if err != nil {
+		return false, err
+	}
+
+	if st.Sys().(*syscall.Stat_t).Nlink > 1 {
+		err = os.Link(dest, pathname)
+		if err != nil && !os.IsExist(err) {
+			return false, err
+		}
+		return false, os.Remove(dest)
+	}
+	return true, os.Remove(dest)
+}
+
 func (repository *FSRepository) PutObject(checksum string, data []byte) error {
 	f, err := ioutil.TempFile(repository.PathObjectBucket(checksum), fmt.Sprintf("%s.*", checksum))
 	if err != nil {
@@ -425,10 +555,10 @@ func (repository *FSRepository) GetChunks() ([]string, error) {
 		}
 
 		for _, chunk := range chunks {
-			//_, err = uuid.Parse(object.Name())
-			//if err != nil {
-			//		return ret, nil
-			//	}
+			// temporary files of writes in progress
+			if strings.Contains(chunk.Name(), ".") {
+				continue
+			}
 			ret = append(ret, chunk.Name())
 		}
 	}
@@ -523,6 +653,32 @@ func (repository *FSRepository) CheckChunk(checksum string) (bool, error) {
 
 }
 
+func (repository *FSRepository) GetHold(id string) ([]byte, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("%s/HOLD", repository.PathIndex(id)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (repository *FSRepository) PutHold(id string, data []byte) error {
+	if !pathnameExists(repository.PathIndex(id)) {
+		return fmt.Errorf("%s: no such snapshot", id)
+	}
+	return putFile(repository.PathIndex(id), "HOLD", data)
+}
+
+func (repository *FSRepository) DeleteHold(id string) error {
+	err := os.Remove(fmt.Sprintf("%s/HOLD", repository.PathIndex(id)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 func (repository *FSRepository) Purge(id string) error {
 	dest := fmt.Sprintf("%s/%s", repository.PathPurge(), id)
 	err := os.Rename(repository.PathIndex(id), dest)
@@ -535,18 +691,38 @@ func (repository *FSRepository) Purge(id string) error {
 		return err
 	}
 
-	repository.dirty = true
+	repository.markDirty()
 
 	return nil
 }
 
 func (repository *FSRepository) Close() error {
-	// XXX - rollback all pending transactions so they don't linger
-	if repository.dirty {
-		repository.Tidy()
-		repository.dirty = false
+	// pending transactions are rolled back so they don't linger, unless
+	// they were checkpointed and can be resumed
+	repository.muTransactions.Lock()
+	pending := make([]*FSTransaction, 0, len(repository.transactions))
+	for _, tx := range repository.transactions {
+		pending = append(pending, tx)
+	}
+	repository.muTransactions.Unlock()
+
+	for _, tx := range pending {
+		if pathnameExists(fmt.Sprintf("%s/METADATA", tx.Path())) {
+			tx.release()
+			continue
+		}
+		if err := tx.Rollback(); err != nil {
+			logger.Warn("%s: could not roll back transaction: %s", tx.Uuid, err)
+		}
 	}
-	return nil
+
+	return repository.Unlock()
+}
+
+func (repository *FSRepository) markDirty() {
+	repository.muDirty.Lock()
+	repository.dirty = true
+	repository.muDirty.Unlock()
 }
 
 func (repository *FSRepository) Tidy() {
@@ -607,7 +783,7 @@ func (transaction *FSTransaction) CreateChunkBucket(checksum string) error {
 	bucket := checksum[0:2]
 	if _, exists := transaction.chunkBucket[bucket]; !exists {
 		err := os.Mkdir(transaction.PathChunkBucket(checksum), 0700)
-		if err != nil {
+		if err != nil && !os.IsExist(err) {
 			return err
 		}
 		transaction.chunkBucket[bucket] = true
@@ -622,7 +798,7 @@ func (transaction *FSTransaction) CreateObjectBucket(checksum string) error {
 	bucket := checksum[0:2]
 	if _, exists := transaction.objectBucket[bucket]; !exists {
 		err := os.Mkdir(transaction.PathObjectBucket(checksum), 0700)
-		if err != nil {
+		if err != nil && !os.IsExist(err) {
 			return err
 		}
 		transaction.objectBucket[bucket] = true
@@ -634,13 +810,50 @@ func (transaction *FSTransaction) GetUuid() string {
 	return transaction.Uuid
 }
 
-func (transaction *FSTransaction) prepare() {
+func (transaction *FSTransaction) prepare() error {
 	os.MkdirAll(transaction.repository.root, 0700)
 	os.MkdirAll(fmt.Sprintf("%s/%s", transaction.repository.PathTransactions(),
 		transaction.Uuid[0:2]), 0700)
 	os.MkdirAll(transaction.Path(), 0700)
 	os.MkdirAll(fmt.Sprintf("%s/chunks", transaction.Path()), 0700)
 	os.MkdirAll(fmt.Sprintf("%s/objects", transaction.Path()), 0700)
+
+	lock, err := os.OpenFile(fmt.Sprintf("%s/LOCK", transaction.Path()), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		lock.Close()
+		if err == syscall.EWOULDBLOCK {
+			return fmt.Errorf("%s: %w", transaction.Uuid, storage.ErrTransactionInUse)
+		}
+		return err
+	}
+	transaction.lock = lock
+
+	repository := transaction.repository
+	repository.muTransactions.Lock()
+	if repository.transactions == nil {
+		repository.transactions = make(map[string]*FSTransaction)
+	}
+	repository.transactions[transaction.Uuid] = transaction
+	repository.muTransactions.Unlock()
+
+	return nil
+}
+
+// release gives up the transaction, leaving it in place.
+func (transaction *FSTransaction) release() {
+	repository := transaction.repository
+	repository.muTransactions.Lock()
+	delete(repository.transactions, transaction.Uuid)
+	repository.muTransactions.Unlock()
+
+	if transaction.lock != nil {
+		transaction.lock.Close()
+		transaction.lock = nil
+	}
 }
 
 func (transaction *FSTransaction) ReferenceChunks(keys []string) ([]bool, error) {
@@ -714,7 +927,7 @@ func (transaction *FSTransaction) PutObject(checksum string, data []byte) error
 
 	transaction.objectsMutex.Lock()
 	transaction.objects[checksum] = true
-	transaction.repository.dirty = true
+	transaction.repository.markDirty()
 	transaction.objectsMutex.Unlock()
 	return nil
 }
@@ -734,13 +947,24 @@ func (transaction *FSTransaction) PutChunk(checksum string, data []byte) error {
 
 	transaction.chunksMutex.Lock()
 	transaction.chunks[checksum] = true
-	transaction.repository.dirty = true
+	transaction.repository.markDirty()
 	transaction.chunksMutex.Unlock()
 	return nil
 }
 
 func (transaction *FSTransaction) PutMetadata(data []byte) error {
-	f, err := os.Create(fmt.Sprintf("%s/METADATA", transaction.Path()))
+	return putFile(transaction.Path(), "METADATA", data)
+}
+
+func (transaction *FSTransaction) PutIndex(data []byte) error {
+	return putFile(transaction.Path(), "INDEX", data)
+}
+
+// putFile writes to a temporary file renamed into place, as metadata and
+// index are rewritten at each checkpoint and metadata when tags change, an
+// interrupted write must not lose the previous one
+func putFile(dir string, name string, data []byte) error {
+	f, err := ioutil.TempFile(dir, fmt.Sprintf("%s.*", name))
 	if err != nil {
 		return err
 	}
@@ -748,28 +972,45 @@ func (transaction *FSTransaction) PutMetadata(data []byte) error {
 
 	_, err = f.Write(data)
 	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	err = os.Rename(f.Name(), fmt.Sprintf("%s/%s", dir, name))
+	if err != nil {
+		os.Remove(f.Name())
 		return err
 	}
 
 	return nil
 }
 
-func (transaction *FSTransaction) PutIndex(data []byte) error {
-	f, err := os.Create(fmt.Sprintf("%s/INDEX", transaction.Path()))
+func (transaction *FSTransaction) Commit() error {
+	defer transaction.release()
+
+	os.Remove(fmt.Sprintf("%s/LOCK", transaction.Path()))
+	return os.Rename(transaction.Path(), transaction.repository.PathIndex(transaction.Uuid))
+}
+
+// Rollback removes the transaction. Chunks and objects it was the only one
+// to reference can't be removed while other pushes run, a tidy is queued
+// for the next time the repository is locked exclusively.
+func (transaction *FSTransaction) Rollback() error {
+	defer transaction.release()
+
+	// moved aside first so that a partial removal never looks like a
+	// transaction that can be resumed
+	dest := fmt.Sprintf("%s/%s", transaction.repository.PathPurge(), transaction.Uuid)
+	err := os.Rename(transaction.Path(), dest)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	_, err = f.Write(data)
+	err = os.RemoveAll(dest)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func (transaction *FSTransaction) Commit() error {
-	transaction.repository.dirty = false
-	return os.Rename(transaction.Path(), transaction.repository.PathIndex(transaction.Uuid))
+	transaction.repository.markDirty()
+	return putFile(transaction.repository.root, "TIDY", []byte{})
 }
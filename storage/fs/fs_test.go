@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2021 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package fs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/poolpOrg/plakar/storage"
+)
+
+func TestConcurrentTransactions(t *testing.T) {
+	repository := NewFSRepository().(*FSRepository)
+	err := repository.Create(filepath.Join(t.TempDir(), "plakar"), storage.RepositoryConfig{Uuid: uuid.NewString()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.Lock(false); err != nil {
+		t.Fatal(err)
+	}
+
+	blob := func(name string, i int) (string, []byte) {
+		data := []byte(fmt.Sprintf("%s %d", name, i))
+		return fmt.Sprintf("%x", sha256.Sum256(data)), data
+	}
+
+	transactions := make(map[string]*FSTransaction)
+	var wg sync.WaitGroup
+	for _, name := range []string{"rolled back", "committed"} {
+		tx, err := repository.Transaction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		transactions[name] = tx.(*FSTransaction)
+		for i := 0; i < 16; i++ {
+			chunkChecksum, chunk := blob(name+" chunk", i)
+			objectChecksum, object := blob(name+" object", i)
+			wg.Add(2)
+			go func(tx *FSTransaction) {
+				defer wg.Done()
+				if err := tx.PutChunk(chunkChecksum, chunk); err != nil {
+					t.Error(err)
+				}
+			}(transactions[name])
+			go func(tx *FSTransaction) {
+				defer wg.Done()
+				if err := tx.PutObject(objectChecksum, object); err != nil {
+					t.Error(err)
+				}
+			}(transactions[name])
+		}
+	}
+	wg.Wait()
+
+	if err := transactions["rolled back"].Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := transactions["committed"].Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a commit adds references, the rolled back blobs are still garbage
+	repository.muDirty.Lock()
+	dirty := repository.dirty
+	repository.muDirty.Unlock()
+	if !dirty {
+		t.Errorf("repository is no longer dirty after a commit")
+	}
+
+	if err := repository.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.Lock(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 16; i++ {
+		checksum, _ := blob("rolled back chunk", i)
+		if pathnameExists(repository.PathChunk(checksum)) {
+			t.Errorf("chunk %s of the rolled back transaction was not tidied", checksum)
+		}
+		checksum, _ = blob("committed chunk", i)
+		if !pathnameExists(repository.PathChunk(checksum)) {
+			t.Errorf("chunk %s of the committed transaction was tidied", checksum)
+		}
+		checksum, _ = blob("committed object", i)
+		if !pathnameExists(repository.PathObject(checksum)) {
+			t.Errorf("object %s of the committed transaction was tidied", checksum)
+		}
+	}
+	if pathnameExists(repository.PathTidy()) {
+		t.Errorf("tidy marker left after an exclusive lock")
+	}
+}
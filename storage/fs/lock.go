@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2021 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package fs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/poolpOrg/plakar/logger"
+	"github.com/poolpOrg/plakar/storage"
+)
+
+type FSLock struct {
+	pathname string
+	info     storage.LockInfo
+	stop     chan bool
+	done     chan bool
+}
+
+func (repository *FSRepository) Lock(exclusive bool) error {
+	if repository.lock != nil {
+		return fmt.Errorf("repository is already locked")
+	}
+
+	err := os.MkdirAll(repository.PathLocks(), 0700)
+	if err != nil {
+		return err
+	}
+
+	lock := &FSLock{
+		pathname: repository.PathLock(uuid.New().String()),
+		info:     storage.NewLockInfo(exclusive),
+		stop:     make(chan bool),
+		done:     make(chan bool),
+	}
+	err = repository.writeLock(lock)
+	if err != nil {
+		return err
+	}
+
+	// the lock is published before looking for conflicting ones, two
+	// processes racing for conflicting locks both see each other and
+	// back off instead of both getting it
+	locks, err := ioutil.ReadDir(repository.PathLocks())
+	if err != nil {
+		os.Remove(lock.pathname)
+		return err
+	}
+	for _, entry := range locks {
+		pathname := repository.PathLock(entry.Name())
+		if pathname == lock.pathname || strings.Contains(entry.Name(), ".") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(pathname)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			os.Remove(lock.pathname)
+			return err
+		}
+		info := storage.LockInfo{}
+		err = json.Unmarshal(data, &info)
+		if err != nil {
+			os.Remove(lock.pathname)
+			return err
+		}
+
+		if info.IsStale() {
+			logger.Trace("fs: removing stale lock %s", entry.Name())
+			os.Remove(pathname)
+			continue
+		}
+		if info.Conflicts(exclusive) {
+			os.Remove(lock.pathname)
+			return info.Error()
+		}
+	}
+
+	repository.lock = lock
+	go func() {
+		defer close(lock.done)
+		ticker := time.NewTicker(storage.LockRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-lock.stop:
+				return
+			case <-ticker.C:
+				lock.info.Timestamp = time.Now()
+				if err := repository.writeLock(lock); err != nil {
+					logger.Warn("could not refresh lock: %s", err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (repository *FSRepository) Unlock() error {
+	lock := repository.lock
+	if lock == nil {
+		return nil
+	}
+
+	// data removed under an exclusive lock or transactions rolled back
+	// since the last tidy left unreferenced chunks and objects behind,
+	// they can only be tidied while nothing else runs
+	repository.muDirty.Lock()
+	dirty := repository.dirty
+	repository.muDirty.Unlock()
+	if lock.info.Exclusive && (dirty || pathnameExists(repository.PathTidy())) {
+		repository.Tidy()
+		repository.muDirty.Lock()
+		repository.dirty = false
+		repository.muDirty.Unlock()
+		os.Remove(repository.PathTidy())
+	}
+
+	close(lock.stop)
+	<-lock.done
+	repository.lock = nil
+	return os.Remove(lock.pathname)
+}
+
+func (repository *FSRepository) writeLock(lock *FSLock) error {
+	data, err := json.Marshal(lock.info)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(repository.PathLocks(), "lock.*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	f.Close()
+
+	err = os.Rename(f.Name(), lock.pathname)
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
@@ -30,6 +30,18 @@ func (repository *FSRepository) PathPurge() string {
 	return fmt.Sprintf("%s/purge", repository.root)
 }
 
+func (repository *FSRepository) PathTidy() string {
+	return fmt.Sprintf("%s/TIDY", repository.root)
+}
+
+func (repository *FSRepository) PathLocks() string {
+	return fmt.Sprintf("%s/locks", repository.root)
+}
+
+func (repository *FSRepository) PathLock(id string) string {
+	return fmt.Sprintf("%s/%s", repository.PathLocks(), id)
+}
+
 func (repository *FSRepository) PathChunks() string {
 	return fmt.Sprintf("%s/chunks", repository.root)
 }
@@ -42,6 +54,10 @@ func (repository *FSRepository) PathTransactions() string {
 	return fmt.Sprintf("%s/transactions", repository.root)
 }
 
+func (repository *FSRepository) PathTransaction(id string) string {
+	return fmt.Sprintf("%s/%s/%s", repository.PathTransactions(), id[0:2], id)
+}
+
 func (repository *FSRepository) PathIndexes() string {
 	return fmt.Sprintf("%s/snapshots", repository.root)
 }
@@ -95,8 +111,7 @@ func (repository *FSRepository) PathIndexObject(id string, checksum string) stri
 }
 
 func (transaction *FSTransaction) Path() string {
-	return fmt.Sprintf("%s/%s/%s", transaction.repository.PathTransactions(),
-		transaction.Uuid[0:2], transaction.Uuid)
+	return transaction.repository.PathTransaction(transaction.Uuid)
 }
 
 func (transaction *FSTransaction) PathObjects() string {
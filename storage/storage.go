@@ -17,6 +17,8 @@
 package storage
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -24,14 +26,45 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/poolpOrg/plakar/cache"
+	"github.com/poolpOrg/plakar/encryption"
 	"github.com/poolpOrg/plakar/logger"
 )
 
 const VERSION string = "0.1.0"
 
+// ErrTransactionInUse is returned when resuming a transaction that is
+// still in progress in another process.
+var ErrTransactionInUse = errors.New("transaction is in use")
+
+// ErrRepositoryLocked is returned when a lock conflicts with one held by
+// another process.
+var ErrRepositoryLocked = errors.New("repository is locked")
+
+// ErrSnapshotHeld is returned when purging a snapshot that is on hold.
+var ErrSnapshotHeld = errors.New("snapshot is on hold")
+
+// Hold protects a snapshot from being purged, until an expiry date if set.
+type Hold struct {
+	Expiry time.Time
+}
+
+// locks are refreshed while held, a lock that wasn't refreshed in a while
+// belongs to a process that is gone
+const LockRefresh = 5 * time.Minute
+const LockExpiry = 30 * time.Minute
+
+// LockInfo describes the holder of a repository lock.
+type LockInfo struct {
+	Hostname  string
+	Pid       int
+	Exclusive bool
+	Timestamp time.Time
+}
+
 type RepositoryConfig struct {
 	Uuid        string
 	Version     string
@@ -43,9 +76,17 @@ type RepositoryBackend interface {
 	Create(repository string, configuration RepositoryConfig) error
 	Open(repository string) error
 	Configuration() RepositoryConfig
+	SetConfiguration(configuration RepositoryConfig) error
 
 	Transaction() (TransactionBackend, error)
 
+	// transactions that were neither committed nor rolled back, they
+	// can be resumed from the metadata and index of their last checkpoint
+	GetTransactions() ([]string, error)
+	GetTransactionMetadata(id string) ([]byte, error)
+	GetTransactionIndex(id string) ([]byte, error)
+	ResumeTransaction(id string) (TransactionBackend, error)
+
 	GetIndexes() ([]string, error)
 	GetMetadata(id string) ([]byte, error)
 	PutMetadata(id string, data []byte) error
@@ -64,6 +105,7 @@ type RepositoryBackend interface {
 	PutObject(checksum string, data []byte) error
 	GetObjectRefCount(checksum string) (uint64, error)
 	GetObjectSize(checksum string) (uint64, error)
+	DeleteObject(checksum string) (bool, error)
 
 	GetChunks() ([]string, error)
 	GetChunk(checksum string) ([]byte, error)
@@ -71,9 +113,21 @@ type RepositoryBackend interface {
 	PutChunk(checksum string, data []byte) error
 	GetChunkRefCount(checksum string) (uint64, error)
 	GetChunkSize(checksum string) (uint64, error)
+	DeleteChunk(checksum string) (bool, error)
 
 	Purge(id string) error
 
+	// holds are stored in clear so that they can be enforced without
+	// access to the snapshots, there's no hold if GetHold returns nil
+	GetHold(id string) ([]byte, error)
+	PutHold(id string, data []byte) error
+	DeleteHold(id string) error
+
+	// pushes share the repository, maintenance that removes data
+	// requires exclusive access to it
+	Lock(exclusive bool) error
+	Unlock() error
+
 	Close() error
 }
 
@@ -89,6 +143,7 @@ type TransactionBackend interface {
 	PutMetadata(data []byte) error
 	PutIndex(data []byte) error
 	Commit() error
+	Rollback() error
 }
 
 var muBackends sync.Mutex
@@ -102,15 +157,60 @@ type Repository struct {
 	CommandLine string
 	MachineID   string
 
-	Cache *cache.Cache
-	//Keypair *encryption.Keypair
-	Key []byte
+	Cache       *cache.Cache
+	Keypair     *encryption.Keypair
+	TrustedKeys [][]byte
+	Key         []byte
+	PublicKey   []byte
 }
 
 type Transaction struct {
 	backend TransactionBackend
 }
 
+// IsActive reports whether the hold still protects the snapshot.
+func (hold *Hold) IsActive() bool {
+	return hold.Expiry.IsZero() || time.Now().Before(hold.Expiry)
+}
+
+func NewLockInfo(exclusive bool) LockInfo {
+	hostname, _ := os.Hostname()
+	return LockInfo{
+		Hostname:  hostname,
+		Pid:       os.Getpid(),
+		Exclusive: exclusive,
+		Timestamp: time.Now(),
+	}
+}
+
+// IsStale reports whether the process holding the lock is gone, either
+// because it stopped refreshing it or because it no longer runs on this
+// host.
+func (lock LockInfo) IsStale() bool {
+	if time.Since(lock.Timestamp) > LockExpiry {
+		return true
+	}
+	hostname, _ := os.Hostname()
+	if lock.Hostname != hostname {
+		return false
+	}
+	return syscall.Kill(lock.Pid, 0) == syscall.ESRCH
+}
+
+// Conflicts reports whether lock can't be held along with a lock of the
+// given kind.
+func (lock LockInfo) Conflicts(exclusive bool) bool {
+	return lock.Exclusive || exclusive
+}
+
+func (lock LockInfo) Error() error {
+	kind := "shared"
+	if lock.Exclusive {
+		kind = "exclusive"
+	}
+	return fmt.Errorf("%w: %s lock held by %s (pid %d)", ErrRepositoryLocked, kind, lock.Hostname, lock.Pid)
+}
+
 func Register(name string, backend func() RepositoryBackend) {
 	muBackends.Lock()
 	defer muBackends.Unlock()
@@ -210,6 +310,23 @@ func (repository *Repository) GetSecret() []byte {
 	return repository.Key
 }
 
+// GetPublicKey returns the repository public key when operating in
+// write-only mode, blobs are then sealed to it as no secret is known.
+func (repository *Repository) GetPublicKey() []byte {
+	if len(repository.PublicKey) == 0 {
+		return nil
+	}
+	return repository.PublicKey
+}
+
+func (repository *Repository) GetKeypair() *encryption.Keypair {
+	return repository.Keypair
+}
+
+func (repository *Repository) GetTrustedKeys() [][]byte {
+	return repository.TrustedKeys
+}
+
 func (repository *Repository) GetUsername() string {
 	return repository.Username
 }
@@ -236,6 +353,21 @@ func (repository *Repository) SetSecret(secret []byte) error {
 	return nil
 }
 
+func (repository *Repository) SetPublicKey(publicKey []byte) error {
+	repository.PublicKey = publicKey
+	return nil
+}
+
+func (repository *Repository) SetKeypair(keypair *encryption.Keypair) error {
+	repository.Keypair = keypair
+	return nil
+}
+
+func (repository *Repository) SetTrustedKeys(trustedKeys [][]byte) error {
+	repository.TrustedKeys = trustedKeys
+	return nil
+}
+
 func (repository *Repository) SetUsername(username string) error {
 	repository.Username = username
 	return nil
@@ -260,6 +392,14 @@ func (repository *Repository) Configuration() RepositoryConfig {
 	return repository.backend.Configuration()
 }
 
+func (repository *Repository) SetConfiguration(configuration RepositoryConfig) error {
+	t0 := time.Now()
+	defer func() {
+		logger.Profile("storage: SetConfiguration(): %s", time.Since(t0))
+	}()
+	return repository.backend.SetConfiguration(configuration)
+}
+
 func (repository *Repository) Transaction() (*Transaction, error) {
 	t0 := time.Now()
 	defer func() {
@@ -275,6 +415,45 @@ func (repository *Repository) Transaction() (*Transaction, error) {
 	return wrapperTx, nil
 }
 
+func (repository *Repository) GetTransactions() ([]string, error) {
+	t0 := time.Now()
+	defer func() {
+		logger.Profile("storage: GetTransactions(): %s", time.Since(t0))
+	}()
+	return repository.backend.GetTransactions()
+}
+
+func (repository *Repository) GetTransactionMetadata(id string) ([]byte, error) {
+	t0 := time.Now()
+	defer func() {
+		logger.Profile("storage: GetTransactionMetadata(%s): %s", id, time.Since(t0))
+	}()
+	return repository.backend.GetTransactionMetadata(id)
+}
+
+func (repository *Repository) GetTransactionIndex(id string) ([]byte, error) {
+	t0 := time.Now()
+	defer func() {
+		logger.Profile("storage: GetTransactionIndex(%s): %s", id, time.Since(t0))
+	}()
+	return repository.backend.GetTransactionIndex(id)
+}
+
+func (repository *Repository) ResumeTransaction(id string) (*Transaction, error) {
+	t0 := time.Now()
+	defer func() {
+		logger.Profile("storage: ResumeTransaction(%s): %s", id, time.Since(t0))
+	}()
+	tx, err := repository.backend.ResumeTransaction(id)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapperTx := &Transaction{}
+	wrapperTx.backend = tx
+	return wrapperTx, nil
+}
+
 func (repository *Repository) GetIndexes() ([]string, error) {
 	t0 := time.Now()
 	defer func() {
@@ -387,6 +566,16 @@ func (repository *Repository) GetObjectSize(checksum string) (uint64, error) {
 	return repository.backend.GetObjectSize(checksum)
 }
 
+// DeleteObject removes an object unless a snapshot or a transaction still
+// references it, in which case it is kept and false is returned.
+func (repository *Repository) DeleteObject(checksum string) (bool, error) {
+	t0 := time.Now()
+	defer func() {
+		logger.Profile("storage: DeleteObject(%s): %s", checksum, time.Since(t0))
+	}()
+	return repository.backend.DeleteObject(checksum)
+}
+
 func (repository *Repository) GetChunks() ([]string, error) {
 	t0 := time.Now()
 	defer func() {
@@ -427,6 +616,16 @@ func (repository *Repository) GetChunkSize(checksum string) (uint64, error) {
 	return repository.backend.GetChunkSize(checksum)
 }
 
+// DeleteChunk removes a chunk unless a snapshot or a transaction still
+// references it, in which case it is kept and false is returned.
+func (repository *Repository) DeleteChunk(checksum string) (bool, error) {
+	t0 := time.Now()
+	defer func() {
+		logger.Profile("storage: DeleteChunk(%s): %s", checksum, time.Since(t0))
+	}()
+	return repository.backend.DeleteChunk(checksum)
+}
+
 func (repository *Repository) CheckIndexObject(id string, checksum string) (bool, error) {
 	t0 := time.Now()
 	defer func() {
@@ -464,9 +663,78 @@ func (repository *Repository) Purge(id string) error {
 	defer func() {
 		logger.Profile("storage: Purge(%s): %s", id, time.Since(t0))
 	}()
+
+	hold, err := repository.GetHold(id)
+	if err != nil {
+		return err
+	}
+	if hold != nil && hold.IsActive() {
+		if hold.Expiry.IsZero() {
+			return fmt.Errorf("%s: %w", id, ErrSnapshotHeld)
+		}
+		return fmt.Errorf("%s: %w until %s", id, ErrSnapshotHeld, hold.Expiry.UTC().Format(time.RFC3339))
+	}
+
 	return repository.backend.Purge(id)
 }
 
+// GetHold returns the hold of a snapshot, or nil if it isn't held.
+func (repository *Repository) GetHold(id string) (*Hold, error) {
+	t0 := time.Now()
+	defer func() {
+		logger.Profile("storage: GetHold(%s): %s", id, time.Since(t0))
+	}()
+
+	data, err := repository.backend.GetHold(id)
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	hold := &Hold{}
+	err = json.Unmarshal(data, hold)
+	if err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+func (repository *Repository) PutHold(id string, hold Hold) error {
+	t0 := time.Now()
+	defer func() {
+		logger.Profile("storage: PutHold(%s): %s", id, time.Since(t0))
+	}()
+
+	data, err := json.Marshal(hold)
+	if err != nil {
+		return err
+	}
+	return repository.backend.PutHold(id, data)
+}
+
+func (repository *Repository) DeleteHold(id string) error {
+	t0 := time.Now()
+	defer func() {
+		logger.Profile("storage: DeleteHold(%s): %s", id, time.Since(t0))
+	}()
+	return repository.backend.DeleteHold(id)
+}
+
+func (repository *Repository) Lock(exclusive bool) error {
+	t0 := time.Now()
+	defer func() {
+		logger.Profile("storage: Lock(%t): %s", exclusive, time.Since(t0))
+	}()
+	return repository.backend.Lock(exclusive)
+}
+
+func (repository *Repository) Unlock() error {
+	t0 := time.Now()
+	defer func() {
+		logger.Profile("storage: Unlock(): %s", time.Since(t0))
+	}()
+	return repository.backend.Unlock()
+}
+
 func (repository *Repository) Close() error {
 	t0 := time.Now()
 	defer func() {
@@ -534,3 +802,11 @@ func (transaction *Transaction) Commit() error {
 	}()
 	return transaction.backend.Commit()
 }
+
+func (transaction *Transaction) Rollback() error {
+	t0 := time.Now()
+	defer func() {
+		logger.Profile("storage: %s.Rollback(): %s", transaction.GetUuid(), time.Since(t0))
+	}()
+	return transaction.backend.Rollback()
+}
@@ -171,6 +171,7 @@ func SnapshotToSummary(snapshot *snapshot.Snapshot) *SnapshotSummary {
 func viewRepository(w http.ResponseWriter, r *http.Request) {
 
 	metadatas, _ := getMetadatas(lrepository)
+	tags := r.URL.Query()["tag"]
 
 	totalFiles := uint64(0)
 
@@ -184,6 +185,9 @@ func viewRepository(w http.ResponseWriter, r *http.Request) {
 
 	res := make([]*snapshot.Metadata, 0)
 	for _, metadata := range metadatas {
+		if !metadata.HasTags(tags) {
+			continue
+		}
 		res = append(res, metadata)
 		totalFiles += metadata.Statistics.Files
 